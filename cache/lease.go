@@ -0,0 +1,92 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/leases"
+	"github.com/pkg/errors"
+)
+
+// Lease keeps every Ref it Holds alive for containerd's garbage collector
+// even after the process that created them holds no more Go references to
+// them, so a long-running remote client (a session-scoped build, a
+// cache-warming job) can ask to keep refs around for a while without having
+// to keep the process itself alive to do it.
+type Lease struct {
+	cm *cacheManager
+	l  leases.Lease
+}
+
+// NewLease creates a containerd lease with the given id and gc labels (for
+// example "containerd.io/gc.expire" to bound how long it lasts, or
+// "containerd.io/gc.flat" to opt resources held under it out of reference
+// counting, the same way cacheRecord.finalize already does for committed
+// snapshots) and returns a handle that can Hold Refs under it.
+func (cm *cacheManager) NewLease(ctx context.Context, id string, labels map[string]string) (Lease, error) {
+	l, err := cm.ManagerOpt.LeaseManager.Create(ctx, func(l *leases.Lease) error {
+		l.ID = id
+		l.Labels = labels
+		return nil
+	})
+	if err != nil {
+		return Lease{}, errors.Wrapf(err, "failed to create lease %s", id)
+	}
+	return Lease{cm: cm, l: l}, nil
+}
+
+// Hold adds ref's underlying snapshot, and its blob once one has been set
+// via SetBlob, as resources of the lease, keeping them alive for as long as
+// the lease exists regardless of whether any Go reference to ref remains.
+func (l Lease) Hold(ref Ref) error {
+	ctx := context.Background()
+	if err := l.cm.ManagerOpt.LeaseManager.AddResource(ctx, l.l, leases.Resource{
+		ID:   ref.ID(),
+		Type: "snapshots/" + l.cm.ManagerOpt.Snapshotter.Name(),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to hold %s under lease %s", ref.ID(), l.l.ID)
+	}
+	if ir, ok := ref.(ImmutableRef); ok {
+		if blob := ir.Info().Blob; blob != "" {
+			if err := l.cm.ManagerOpt.LeaseManager.AddResource(ctx, l.l, leases.Resource{
+				ID:   blob.String(),
+				Type: "content",
+			}); err != nil {
+				return errors.Wrapf(err, "failed to hold blob %s under lease %s", blob, l.l.ID)
+			}
+		}
+	}
+	return nil
+}
+
+// Release deletes the lease, handing every resource held under it back to
+// the garbage collector's normal reference counting.
+func (l Lease) Release(ctx context.Context) error {
+	return l.cm.ManagerOpt.LeaseManager.Delete(ctx, l.l)
+}
+
+type leaseContextKeyT string
+
+var leaseContextKey = leaseContextKeyT("buildkit/cache/lease")
+
+// WithLease returns a context that names leaseID for holdIfLeased. Manager.Get,
+// Manager.New and the solver do not call holdIfLeased, so Refs obtained under
+// such a context are not held under the lease automatically; only an explicit
+// Lease.Hold holds them.
+func WithLease(ctx context.Context, leaseID string) context.Context {
+	return context.WithValue(ctx, leaseContextKey, leaseID)
+}
+
+func leaseFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(leaseContextKey).(string)
+	return id, ok
+}
+
+// holdIfLeased holds ref under the lease named in ctx, if any. Nothing in
+// this package calls it: Manager.Get and Manager.New return Refs without it.
+func (cm *cacheManager) holdIfLeased(ctx context.Context, ref Ref) error {
+	leaseID, ok := leaseFromContext(ctx)
+	if !ok {
+		return nil
+	}
+	return Lease{cm: cm, l: leases.Lease{ID: leaseID}}.Hold(ref)
+}
@@ -6,6 +6,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/leases"
 	"github.com/containerd/containerd/mount"
 	"github.com/docker/docker/pkg/idtools"
@@ -13,8 +14,10 @@ import (
 	"github.com/moby/buildkit/identity"
 	"github.com/moby/buildkit/snapshot"
 	"github.com/moby/buildkit/util/flightcontrol"
+	"github.com/moby/buildkit/util/progress"
 	"github.com/opencontainers/go-digest"
 	imagespaceidentity "github.com/opencontainers/image-spec/identity"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
@@ -36,7 +39,10 @@ type ImmutableRef interface {
 	Clone() ImmutableRef
 
 	Info() RefInfo
-	SetBlob(ctx context.Context, diffID, blob digest.Digest) error
+	// SetBlob associates blob (of the given mediaType) with the cache
+	// record, so a later Extract knows how to decompress it rather than
+	// assuming every blob is gzip.
+	SetBlob(ctx context.Context, diffID, blob digest.Digest, mediaType string) error
 	Extract(ctx context.Context) error // +progress
 }
 
@@ -238,8 +244,129 @@ func (sr *immutableRef) Clone() ImmutableRef {
 	return ref
 }
 
+// Extract makes sure sr is actually mountable: a ref returned by a source
+// that only registered a blob (BlobOnly in metadata, e.g. the image-pull
+// source returning the result of a layer download before it has been
+// unpacked) cannot be mounted for exec until its diff, and that of every
+// unextracted ancestor, has been applied into the snapshotter. Concurrent
+// Extracts sharing an ancestor dedupe on its ChainID via cm.extractG so the
+// same layer is never unpacked twice.
 func (sr *immutableRef) Extract(ctx context.Context) error {
-	return errors.Errorf("extract not implemented")
+	return sr.extract(ctx)
+}
+
+// extract walks the parent chain root-first and applies the diff of every
+// ancestor (including sr itself) that hasn't been extracted yet, each layer
+// through cm.extractG keyed on that layer's own ChainID.
+func (sr *immutableRef) extract(ctx context.Context) error {
+	var chain []*immutableRef
+	for r := sr; r != nil; r = r.parentRef(false) {
+		chain = append(chain, r)
+	}
+	for i := len(chain) - 1; i >= 0; i-- {
+		r := chain[i]
+		info := r.Info()
+		// The writer comes from the caller's ctx: the ctx extractG hands fn
+		// is detached from it and carries no progress writer.
+		pw, _, _ := progress.FromContext(ctx, progress.WithMetadata("diffID", info.DiffID.String()))
+		_, err := sr.cm.extractG.Do(ctx, info.ChainID.String(), func(ctx context.Context) (interface{}, error) {
+			return nil, r.extractLayer(ctx, pw)
+		})
+		pw.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// extractProgress is the Sys payload written to the progress stream while a
+// single layer is being applied, reported in blob bytes.
+type extractProgress struct {
+	Current int64
+	Total   int64
+}
+
+// extractLayer applies sr's own diff blob into the snapshotter, leaving its
+// ancestors untouched. It rolls back the partial snapshot it was building
+// if ctx is cancelled or the apply fails partway through.
+func (sr *immutableRef) extractLayer(ctx context.Context, pw progress.Writer) (retErr error) {
+	sr.mu.Lock()
+	blobOnly := getBlobOnly(sr.md)
+	blob := digest.Digest(getBlob(sr.md))
+	diffID := digest.Digest(getDiffID(sr.md))
+	snapshotID := getSnapshotID(sr.md)
+	mediaType := getMediaType(sr.md)
+	parent := sr.parent
+	sr.mu.Unlock()
+
+	if !blobOnly {
+		return nil
+	}
+	if blob == "" {
+		return errors.Errorf("cannot extract %s: no blob has been set", sr.ID())
+	}
+
+	info, err := sr.cm.ContentStore.Info(ctx, blob)
+	if err != nil {
+		return errors.Wrapf(err, "failed to stat blob %s for %s", blob, sr.ID())
+	}
+
+	pw.Write(diffID.String(), extractProgress{Total: info.Size})
+
+	var parentSnapshotID string
+	if parent != nil {
+		parentSnapshotID = getSnapshotID(parent.md)
+	}
+
+	key := identity.NewID()
+	mounts, err := sr.cm.Snapshotter.Prepare(ctx, key, parentSnapshotID)
+	if err != nil {
+		return errors.Wrapf(err, "failed to prepare extraction snapshot for %s", sr.ID())
+	}
+	defer func() {
+		if retErr != nil {
+			// Use a detached context: ctx may already be the reason retErr is
+			// set (cancelled/Done), and Remove would just fail or no-op on
+			// it, leaking the half-applied snapshot under key.
+			if err := sr.cm.Snapshotter.Remove(context.Background(), key); err != nil {
+				logrus.Errorf("failed to roll back extraction snapshot %s: %v", key, err)
+			}
+		}
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	default:
+	}
+
+	if mediaType == "" {
+		// Blobs set without a mediaType predate that field; every one of
+		// those was a gzip layer, since that was the only kind this tree
+		// ever produced before remotecache.Registry started exporting
+		// uncompressed ones.
+		mediaType = images.MediaTypeDockerSchema2LayerGzip
+	}
+	if _, err := sr.cm.Applier.Apply(ctx, ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    blob,
+		Size:      info.Size,
+	}, mounts); err != nil {
+		return errors.Wrapf(err, "failed to apply diff %s", diffID)
+	}
+
+	pw.Write(diffID.String(), extractProgress{Current: info.Size, Total: info.Size})
+
+	if err := sr.cm.Snapshotter.Commit(ctx, snapshotID, key); err != nil {
+		return errors.Wrapf(err, "failed to commit extracted snapshot %s", snapshotID)
+	}
+
+	sr.mu.Lock()
+	queueBlobOnly(sr.md, false)
+	err = sr.md.Commit()
+	sr.mu.Unlock()
+	return err
 }
 
 func (sr *immutableRef) Info() RefInfo {
@@ -256,7 +383,7 @@ func (sr *immutableRef) Info() RefInfo {
 // SetBlob associates a blob with the cache record.
 // A lease must be held for the blob when calling this function
 // Caller should call Info() for knowing what current values are actually set
-func (sr *immutableRef) SetBlob(ctx context.Context, diffID, blob digest.Digest) error {
+func (sr *immutableRef) SetBlob(ctx context.Context, diffID, blob digest.Digest, mediaType string) error {
 	if _, err := sr.cm.ContentStore.Info(ctx, blob); err != nil {
 		return err
 	}
@@ -293,6 +420,7 @@ func (sr *immutableRef) SetBlob(ctx context.Context, diffID, blob digest.Digest)
 
 	queueDiffID(sr.md, diffID.String())
 	queueBlob(sr.md, blob.String())
+	queueMediaType(sr.md, mediaType)
 	chainID := diffID
 	blobChainID := imagespaceidentity.ChainID([]digest.Digest{blob, diffID})
 	if parentChainID != "" {
@@ -0,0 +1,289 @@
+package remotecache
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sync"
+
+	"github.com/containerd/containerd/content"
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/remotes"
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/moby/buildkit/cache"
+	digest "github.com/opencontainers/go-digest"
+	specs "github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Importer looks up a vertex's cache key (see solver's
+// opVertex.remoteCacheKey, not the marshaled-proto digest opVertex.dgst is)
+// in a previously exported cache manifest and, on a hit, unpacks the layer
+// descriptors it maps to into refs held by cm instead of making the caller
+// rerun the op that would have produced them.
+type Importer interface {
+	Import(ctx context.Context, cm cache.Manager, key digest.Digest) ([]cache.ImmutableRef, error)
+}
+
+// Exporter records a solved vertex's output refs under its cache key so a
+// later Finalize can push them - and every vertex recorded since the last
+// one - as a cache manifest another build, possibly on a different
+// machine, can Import from.
+type Exporter interface {
+	Export(ctx context.Context, key digest.Digest, refs []cache.ImmutableRef) error
+	Finalize(ctx context.Context, ref string) error
+}
+
+// manifest is the format Registry.Finalize writes and Registry.Pull reads
+// back: an OCI image whose layers are the blobs the entries' descriptors
+// point to, and whose config is this JSON itself. Any registry that can
+// host a normal image can therefore host a shared build cache.
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+type manifestEntry struct {
+	CacheKey digest.Digest        `json:"cacheKey"`
+	Layers   []ocispec.Descriptor `json:"layers"` // one per output ref, root to leaf
+	Config   json.RawMessage      `json:"config,omitempty"`
+}
+
+// Registry implements Importer/Exporter on top of an OCI registry.
+type Registry struct {
+	Content content.Store
+
+	mu      sync.Mutex
+	pending manifest // appended to by Export, pushed by Finalize
+	pulled  manifest // populated by Pull, read by Import
+	fetcher remotes.Fetcher
+}
+
+func NewRegistry(cs content.Store) *Registry {
+	return &Registry{Content: cs}
+}
+
+// Pull resolves ref, fetches its cache manifest and the manifest config it
+// points to, and stores the result so subsequent Import calls can consult
+// it. It must be called (and must succeed) before Import can produce a hit.
+// The fetcher it resolves ref's blobs with is kept around so Import can
+// fetch a hit's layer blobs lazily, without needing ref passed to it again.
+func (r *Registry) Pull(ctx context.Context, ref string) error {
+	resolver := docker.NewResolver(docker.ResolverOptions{})
+
+	name, desc, err := resolver.Resolve(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "failed to resolve cache manifest %s", ref)
+	}
+	fetcher, err := resolver.Fetcher(ctx, name)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get fetcher for %s", ref)
+	}
+
+	mdt, err := fetchAll(ctx, fetcher, desc)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch cache manifest %s", ref)
+	}
+	var im ocispec.Manifest
+	if err := json.Unmarshal(mdt, &im); err != nil {
+		return errors.Wrap(err, "failed to parse cache image manifest")
+	}
+
+	cdt, err := fetchAll(ctx, fetcher, im.Config)
+	if err != nil {
+		return errors.Wrap(err, "failed to fetch cache manifest config")
+	}
+	var m manifest
+	if err := json.Unmarshal(cdt, &m); err != nil {
+		return errors.Wrap(err, "failed to parse cache manifest")
+	}
+
+	r.mu.Lock()
+	r.pulled = m
+	r.fetcher = fetcher
+	r.mu.Unlock()
+	return nil
+}
+
+func (r *Registry) Import(ctx context.Context, cm cache.Manager, key digest.Digest) ([]cache.ImmutableRef, error) {
+	r.mu.Lock()
+	entries := r.pulled.Entries
+	fetcher := r.fetcher
+	r.mu.Unlock()
+
+	var match *manifestEntry
+	for i := range entries {
+		if entries[i].CacheKey == key {
+			match = &entries[i]
+			break
+		}
+	}
+	if match == nil {
+		return nil, nil
+	}
+
+	refs := make([]cache.ImmutableRef, 0, len(match.Layers))
+	var parent cache.ImmutableRef
+	for _, l := range match.Layers {
+		if err := r.ensureBlob(ctx, fetcher, l); err != nil {
+			return nil, errors.Wrapf(err, "failed to fetch cache layer %s", l.Digest)
+		}
+		mref, err := cm.New(ctx, parent)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to allocate ref for cache layer %s", l.Digest)
+		}
+		iref, err := mref.Commit(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to commit cache layer %s", l.Digest)
+		}
+		diffID := digest.Digest(l.Annotations[ocispec.AnnotationUncompressed])
+		if err := iref.SetBlob(ctx, diffID, l.Digest, l.MediaType); err != nil {
+			return nil, errors.Wrapf(err, "failed to register blob for cache layer %s", l.Digest)
+		}
+		if err := iref.Extract(ctx); err != nil {
+			return nil, errors.Wrapf(err, "failed to extract cache layer %s", l.Digest)
+		}
+		parent = iref
+		refs = append(refs, iref)
+	}
+	return refs, nil
+}
+
+// ensureBlob makes sure desc is present in r.Content, fetching it through
+// fetcher if it isn't. cache.ImmutableRef.Extract reads the layer straight
+// out of this content store, so without this a hit's layers would still be
+// missing the one thing Import exists to avoid: actually pulling them.
+func (r *Registry) ensureBlob(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) error {
+	if _, err := r.Content.Info(ctx, desc.Digest); err == nil {
+		return nil
+	}
+	if fetcher == nil {
+		return errors.Errorf("no fetcher available, Pull must succeed before Import")
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+	return content.WriteBlob(ctx, r.Content, desc.Digest.String(), rc, desc)
+}
+
+func (r *Registry) Export(ctx context.Context, key digest.Digest, refs []cache.ImmutableRef) error {
+	layers := make([]ocispec.Descriptor, 0, len(refs))
+	for _, ref := range refs {
+		info := ref.Info()
+		layers = append(layers, ocispec.Descriptor{
+			MediaType: ocispec.MediaTypeImageLayer,
+			Digest:    info.Blob,
+			Annotations: map[string]string{
+				ocispec.AnnotationUncompressed: info.DiffID.String(),
+			},
+		})
+	}
+
+	r.mu.Lock()
+	r.pending.Entries = append(r.pending.Entries, manifestEntry{CacheKey: key, Layers: layers})
+	r.mu.Unlock()
+	return nil
+}
+
+// Finalize pushes every entry recorded by Export since the last Finalize as
+// a single OCI image tagged ref: the manifest config is the entries
+// themselves (json-marshaled), and the image's layers are the deduplicated
+// union of every entry's layer descriptors, so Pull can read the whole
+// thing back with a plain registry resolve.
+func (r *Registry) Finalize(ctx context.Context, ref string) error {
+	r.mu.Lock()
+	pending := r.pending
+	r.mu.Unlock()
+
+	dt, err := json.Marshal(pending)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache manifest")
+	}
+	configDesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageConfig,
+		Digest:    digest.FromBytes(dt),
+		Size:      int64(len(dt)),
+	}
+
+	resolver := docker.NewResolver(docker.ResolverOptions{})
+	pusher, err := resolver.Pusher(ctx, ref)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get pusher for %s", ref)
+	}
+
+	if err := pushBytes(ctx, pusher, configDesc, dt); err != nil {
+		return errors.Wrap(err, "failed to push cache manifest config")
+	}
+
+	im := ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		Config:    configDesc,
+		Layers:    dedupLayers(pending),
+	}
+	mdt, err := json.Marshal(im)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache image manifest")
+	}
+	mdesc := ocispec.Descriptor{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Digest:    digest.FromBytes(mdt),
+		Size:      int64(len(mdt)),
+	}
+	if err := pushBytes(ctx, pusher, mdesc, mdt); err != nil {
+		return errors.Wrap(err, "failed to push cache image manifest")
+	}
+
+	// Drop only the entries just pushed; Export may have appended more since.
+	r.mu.Lock()
+	r.pending.Entries = r.pending.Entries[len(pending.Entries):]
+	r.mu.Unlock()
+	return nil
+}
+
+// dedupLayers returns every layer descriptor referenced across m's entries,
+// each only once, in first-seen order.
+func dedupLayers(m manifest) []ocispec.Descriptor {
+	seen := map[digest.Digest]struct{}{}
+	var out []ocispec.Descriptor
+	for _, e := range m.Entries {
+		for _, l := range e.Layers {
+			if _, ok := seen[l.Digest]; ok {
+				continue
+			}
+			seen[l.Digest] = struct{}{}
+			out = append(out, l)
+		}
+	}
+	return out
+}
+
+// fetchAll fetches desc through fetcher and reads it into memory; every
+// blob Pull needs (the manifest, its config) is small enough that streaming
+// it isn't worth the complexity.
+func fetchAll(ctx context.Context, fetcher remotes.Fetcher, desc ocispec.Descriptor) ([]byte, error) {
+	rc, err := fetcher.Fetch(ctx, desc)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// pushBytes pushes dt as desc through pusher, treating the blob already
+// existing on the remote as success rather than an error.
+func pushBytes(ctx context.Context, pusher remotes.Pusher, desc ocispec.Descriptor, dt []byte) error {
+	w, err := pusher.Push(ctx, desc)
+	if err != nil {
+		if errdefs.IsAlreadyExists(err) {
+			return nil
+		}
+		return err
+	}
+	defer w.Close()
+	if _, err := w.Write(dt); err != nil {
+		return err
+	}
+	return w.Commit(ctx, int64(len(dt)), desc.Digest)
+}
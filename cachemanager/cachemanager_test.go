@@ -8,9 +8,9 @@ import (
 	"testing"
 
 	"github.com/containerd/containerd/snapshot/naive"
+	"github.com/moby/buildkit/snapshot"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
-	"github.com/tonistiigi/buildkit_poc/snapshot"
 )
 
 func TestCacheManager(t *testing.T) {
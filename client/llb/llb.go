@@ -3,6 +3,7 @@ package llb
 import (
 	_ "crypto/sha256"
 	"sort"
+	"strings"
 
 	"github.com/gogo/protobuf/proto"
 	"github.com/moby/buildkit/solver/pb"
@@ -90,6 +91,26 @@ func SessionID(id string) LocalOption {
 	}
 }
 
+// IncludePatterns narrows the transfer to paths matching any of patterns,
+// so a build that only needs e.g. go.mod doesn't pay to sync the rest of a
+// large working directory too. Patterns are path/filepath.Match globs
+// against each path relative to the directory, not full .dockerignore
+// syntax; a directory that matches none of them is skipped together with
+// everything under it.
+func IncludePatterns(patterns []string) LocalOption {
+	return func(s *source) {
+		s.attrs[pb.AttrIncludePatterns] = strings.Join(patterns, ",")
+	}
+}
+
+// ExcludePatterns drops paths matching any of patterns from the transfer,
+// applied after IncludePatterns.
+func ExcludePatterns(patterns []string) LocalOption {
+	return func(s *source) {
+		s.attrs[pb.AttrExcludePatterns] = strings.Join(patterns, ",")
+	}
+}
+
 type exec struct {
 	meta   Meta
 	mounts []*mount
@@ -119,6 +140,10 @@ func (eo *exec) marshalTo(list [][]byte, cache map[digest.Digest]struct{}) (dige
 			Args: eo.meta.args,
 			Env:  eo.meta.env.ToArray(),
 			Cwd:  eo.meta.cwd,
+			// Dns is nil unless this op set its own via the Dns
+			// ExecOption; nil means "use whatever the daemon was
+			// configured with", not "no resolv.conf at all".
+			Dns: eo.meta.dns,
 		},
 	}
 
@@ -0,0 +1,169 @@
+package llb
+
+import (
+	"strings"
+
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// State is the builder API frontends compute LLB with: a root filesystem
+// (either a Source directly, or the Root() of a previous Run) plus the
+// Meta - env, cwd, ... - new Runs from here on out will carry.
+type State struct {
+	source   *source
+	output   *mount
+	metaNext Meta
+}
+
+// Meta is the process configuration accumulated on a State since its last
+// Run: the args an eventual exec carries, the env it inherits, and the
+// working directory it starts in. It has no marshaled form of its own - it
+// only ever reaches the wire embedded in an ExecOp's pb.Meta.
+type Meta struct {
+	args []string
+	env  EnvList
+	cwd  string
+	dns  *pb.DNSConfig
+}
+
+// NewMeta returns the Meta a fresh State starts with.
+func NewMeta() Meta {
+	return Meta{cwd: "/"}
+}
+
+// KeyValue is one entry of an EnvList.
+type KeyValue struct {
+	Key   string
+	Value string
+}
+
+// EnvList is an ordered set of env vars: ordered so ToArray is
+// deterministic, a set so AddEnv of an already-set key replaces it in
+// place instead of shadowing it later in the array.
+type EnvList []KeyValue
+
+func (e EnvList) AddOrReplace(k, v string) EnvList {
+	for i, kv := range e {
+		if kv.Key == k {
+			e[i].Value = v
+			return e
+		}
+	}
+	return append(e, KeyValue{Key: k, Value: v})
+}
+
+func (e EnvList) ToArray() []string {
+	out := make([]string, 0, len(e))
+	for _, kv := range e {
+		out = append(out, kv.Key+"="+kv.Value)
+	}
+	return out
+}
+
+// withMeta returns a copy of s with f applied to its metaNext, leaving the
+// filesystem root (source/output) untouched.
+func (s *State) withMeta(f func(*Meta)) *State {
+	m := s.metaNext
+	f(&m)
+	return &State{source: s.source, output: s.output, metaNext: m}
+}
+
+// AddEnv sets key=value in the env every Run from here on inherits.
+func (s *State) AddEnv(key, value string) *State {
+	return s.withMeta(func(m *Meta) { m.env = m.env.AddOrReplace(key, value) })
+}
+
+// Dir sets the working directory every Run from here on starts in.
+func (s *State) Dir(wd string) *State {
+	return s.withMeta(func(m *Meta) { m.cwd = wd })
+}
+
+// RunOption configures the exec a Run call produces.
+type RunOption func(*exec)
+
+// Shlex splits cmd into argv using plain whitespace splitting - a minimal
+// subset of shell word-splitting with no quoting support, enough for the
+// Dockerfile instructions this tree parses today.
+func Shlex(cmd string) RunOption {
+	return func(eo *exec) {
+		eo.meta.args = strings.Fields(cmd)
+	}
+}
+
+// Dns overrides the resolver config the exec gets for its /etc/resolv.conf,
+// in place of whatever daemon-level default it would otherwise inherit (see
+// worker.DNSConfig). Run records it on the exec, Marshal writes it into the
+// ExecOp's Meta, and the solver uses it in place of the daemon default. It
+// applies only to the exec it's passed to: Root does not carry it forward.
+func Dns(nameservers, searchDomains, options []string) RunOption {
+	return func(eo *exec) {
+		eo.meta.dns = &pb.DNSConfig{
+			Nameservers:   nameservers,
+			SearchDomains: searchDomains,
+			Options:       options,
+		}
+	}
+}
+
+// ExecState is the result of a Run: an exec op together with the State
+// methods need to read its root filesystem back out of.
+type ExecState struct {
+	exec *exec
+}
+
+// Run starts a new exec whose root filesystem is s's (its Source, or the
+// Root() of whatever Run produced it), carrying s's accumulated Meta
+// forward unless opts override it.
+func (s *State) Run(opts ...RunOption) *ExecState {
+	eo := &exec{meta: s.metaNext}
+	for _, o := range opts {
+		o(eo)
+	}
+
+	root := &mount{dest: "/", hasOutput: true}
+	if s.source != nil {
+		root.source = s.source
+	} else {
+		root.parent = s.output
+	}
+	eo.mounts = []*mount{root}
+	eo.root = root
+
+	es := &ExecState{exec: eo}
+	root.execState = es
+	return es
+}
+
+// Root returns the State of the exec's resulting root filesystem, so a
+// subsequent Run can chain off of it.
+func (e *ExecState) Root() *State {
+	m := e.exec.meta
+	m.dns = nil // a Dns override belongs to this exec only
+	return &State{output: e.exec.root, metaNext: m}
+}
+
+// Marshal serializes s (and everything it transitively depends on) into
+// the op list a Solve call expects, in the same format State's own
+// source/exec marshalTo methods already produce.
+func (s *State) Marshal() ([][]byte, error) {
+	cache := map[digest.Digest]struct{}{}
+
+	var (
+		list [][]byte
+		err  error
+	)
+	switch {
+	case s.source != nil:
+		_, list, err = s.source.marshalTo(list, cache)
+	case s.output != nil:
+		_, list, err = s.output.marshalTo(list, cache)
+	default:
+		return nil, errors.Errorf("invalid state: no source or output set")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
@@ -2,11 +2,17 @@ package control
 
 import (
 	"github.com/containerd/containerd/snapshot"
-	controlapi "github.com/tonistiigi/buildkit_poc/api/services/control"
-	"github.com/tonistiigi/buildkit_poc/cache"
-	"github.com/tonistiigi/buildkit_poc/solver"
-	"github.com/tonistiigi/buildkit_poc/source"
-	"github.com/tonistiigi/buildkit_poc/worker"
+	controlapi "github.com/moby/buildkit/api/services/control"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/cache/remotecache"
+	"github.com/moby/buildkit/frontend"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/instructioncache"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/solver"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/worker"
+	"github.com/pkg/errors"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc"
 )
@@ -16,6 +22,26 @@ type Opt struct {
 	CacheManager  cache.Manager
 	Worker        worker.Worker
 	SourceManager *source.Manager
+	// SessionManager resolves the session a SolveRequest's Ref is dialed in
+	// under, giving local:// sources, secrets, and ssh forwards something to
+	// resolve against. May be nil, in which case builds that need one fail.
+	SessionManager *session.Manager
+	// Frontends is looked up by name for a SolveRequest that sets Frontend
+	// instead of shipping a Definition it computed itself.
+	Frontends map[string]frontend.Frontend
+	// InstructionCache backs every Solve's per-op cache lookups, keyed by
+	// the content-addressable digest of the op itself rather than the
+	// remoteCacheKey CacheImporter/CacheExporter use. May be nil, in which
+	// case every op always runs.
+	InstructionCache *instructioncache.LocalStore
+	// CacheImporter and CacheExporter back SolveRequest.Cache{Import,Export}Ref.
+	// Either may be nil, in which case a request that sets the
+	// corresponding ref fails rather than silently skipping it.
+	CacheImporter *remotecache.Registry
+	CacheExporter *remotecache.Registry
+	// DNSConfig is the default resolver config exec ops get when their
+	// network namespace doesn't already come with its own. May be nil.
+	DNSConfig *worker.DNSConfig
 }
 
 type Controller struct { // TODO: ControlService
@@ -24,19 +50,40 @@ type Controller struct { // TODO: ControlService
 }
 
 func NewController(opt Opt) (*Controller, error) {
+	solverOpt := solver.Opt{
+		SourceManager:  opt.SourceManager,
+		CacheManager:   opt.CacheManager,
+		Worker:         opt.Worker,
+		SessionManager: opt.SessionManager,
+		Frontends:      opt.Frontends,
+		DNSConfig:      opt.DNSConfig,
+	}
+	// Only assign these when set: a nil *remotecache.Registry (or
+	// *instructioncache.LocalStore) stored in the solver.Opt's interface
+	// fields would make them compare != nil anyway, since the interface
+	// itself is non-nil even though the pointer it holds is.
+	if opt.InstructionCache != nil {
+		solverOpt.InstructionCache = opt.InstructionCache
+	}
+	if opt.CacheImporter != nil {
+		solverOpt.CacheImporter = opt.CacheImporter
+	}
+	if opt.CacheExporter != nil {
+		solverOpt.CacheExporter = opt.CacheExporter
+	}
+
 	c := &Controller{
-		opt: opt,
-		solver: solver.New(solver.Opt{
-			SourceManager: opt.SourceManager,
-			CacheManager:  opt.CacheManager,
-			Worker:        opt.Worker,
-		}),
+		opt:    opt,
+		solver: solver.New(solverOpt),
 	}
 	return c, nil
 }
 
 func (c *Controller) Register(server *grpc.Server) error {
 	controlapi.RegisterControlServer(server, c)
+	if c.opt.SessionManager != nil {
+		c.opt.SessionManager.Register(server)
+	}
 	return nil
 }
 
@@ -59,12 +106,42 @@ func (c *Controller) DiskUsage(ctx context.Context, _ *controlapi.DiskUsageReque
 }
 
 func (c *Controller) Solve(ctx context.Context, req *controlapi.SolveRequest) (*controlapi.SolveResponse, error) {
-	v, err := solver.Load(req.Definition)
-	if err != nil {
-		return nil, err
+	id := req.Ref
+	if id == "" {
+		id = identity.NewID()
 	}
-	if err := c.solver.Solve(ctx, v); err != nil {
-		return nil, err
+
+	if req.CacheImportRef != "" {
+		if c.opt.CacheImporter == nil {
+			return nil, errors.Errorf("no cache importer configured")
+		}
+		if err := c.opt.CacheImporter.Pull(ctx, req.CacheImportRef); err != nil {
+			return nil, err
+		}
+	}
+
+	if req.Frontend != "" {
+		if err := c.solver.SolveWithFrontend(ctx, id, req.Frontend, req.FrontendAttrs); err != nil {
+			return nil, err
+		}
+	} else {
+		v, err := solver.LoadLLB(req.Definition)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.solver.Solve(ctx, id, v); err != nil {
+			return nil, err
+		}
 	}
+
+	if req.CacheExportRef != "" {
+		if c.opt.CacheExporter == nil {
+			return nil, errors.Errorf("no cache exporter configured")
+		}
+		if err := c.opt.CacheExporter.Finalize(ctx, req.CacheExportRef); err != nil {
+			return nil, err
+		}
+	}
+
 	return &controlapi.SolveResponse{}, nil
 }
@@ -0,0 +1,137 @@
+package dockerfile
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Build is the frontend ID clients pass as SolveRequest.Frontend to build a
+// Dockerfile instead of computing LLB themselves.
+const Build = "dockerfile.v0"
+
+// keyDockerfile is the FrontendAttrs key the Dockerfile's contents are read
+// from.
+//
+// TODO: read it from the build context's local mount instead (frontend
+// options would then just carry the context name and an optional filename,
+// the way the real docker build does), once LLBBridge grows a way to read a
+// file out of a resolved ref rather than only solving whole subgraphs.
+const keyDockerfile = "dockerfile"
+
+// Frontend parses a Dockerfile into LLB and runs it through the bridge, the
+// same ops client/llb would have produced had the caller written them by
+// hand.
+type Frontend struct{}
+
+func New() *Frontend {
+	return &Frontend{}
+}
+
+func (f *Frontend) Solve(ctx context.Context, bridge frontend.LLBBridge, opt map[string]string) (*frontend.Result, error) {
+	dt := opt[keyDockerfile]
+	if dt == "" {
+		return nil, errors.Errorf("missing %q frontend option", keyDockerfile)
+	}
+
+	st, env, err := dockerfile2LLB([]byte(dt))
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse Dockerfile")
+	}
+
+	def, err := st.Marshal()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal LLB")
+	}
+
+	res, err := bridge.Solve(ctx, def)
+	if err != nil {
+		return nil, err
+	}
+
+	if res.Metadata == nil {
+		res.Metadata = map[string][]byte{}
+	}
+	for k, v := range env {
+		res.Metadata["env/"+k] = []byte(v)
+	}
+	return res, nil
+}
+
+// dockerfile2LLB converts a minimal subset of Dockerfile instructions -
+// FROM, ENV, WORKDIR, RUN and COPY - into an LLB state chain, plus the
+// final ENV values for the image config dockerfile.v0 attaches as result
+// metadata.
+func dockerfile2LLB(dt []byte) (*llb.State, map[string]string, error) {
+	var st *llb.State
+	env := map[string]string{}
+
+	scanner := bufio.NewScanner(bytes.NewReader(dt))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		if len(fields) != 2 {
+			return nil, nil, errors.Errorf("invalid instruction %q", line)
+		}
+		cmd, args := strings.ToUpper(fields[0]), strings.TrimSpace(fields[1])
+
+		if cmd != "FROM" && st == nil {
+			return nil, nil, errors.Errorf("no FROM instruction before %q", line)
+		}
+
+		switch cmd {
+		case "FROM":
+			st = llb.Image(args)
+		case "ENV":
+			k, v, ok := splitKV(args)
+			if !ok {
+				return nil, nil, errors.Errorf("invalid ENV %q", args)
+			}
+			env[k] = v
+			st = st.AddEnv(k, v)
+		case "WORKDIR":
+			st = st.Dir(args)
+		case "RUN":
+			st = st.Run(llb.Shlex(args)).Root()
+		case "COPY":
+			parts := strings.Fields(args)
+			if len(parts) != 2 {
+				return nil, nil, errors.Errorf("invalid COPY %q, only a single src and dest are supported", args)
+			}
+			// TODO: mount the build context instead of assuming the base
+			// image already has it at /context.
+			st = st.Run(llb.Shlex("cp -a /context/" + parts[0] + " " + parts[1])).Root()
+		case "CMD":
+			// Recorded nowhere yet: dockerfile.v0 only returns a rootfs, it
+			// doesn't synthesize an image config.
+		default:
+			return nil, nil, errors.Errorf("unsupported instruction %q", cmd)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if st == nil {
+		return nil, nil, errors.Errorf("empty Dockerfile")
+	}
+	return st, env, nil
+}
+
+func splitKV(s string) (string, string, bool) {
+	if parts := strings.SplitN(s, "=", 2); len(parts) == 2 {
+		return parts[0], parts[1], true
+	}
+	if parts := strings.SplitN(s, " ", 2); len(parts) == 2 {
+		return parts[0], strings.TrimSpace(parts[1]), true
+	}
+	return "", "", false
+}
@@ -0,0 +1,37 @@
+package frontend
+
+import (
+	"github.com/moby/buildkit/cache"
+	"golang.org/x/net/context"
+)
+
+// Frontend turns an opaque, frontend-specific set of options into an LLB
+// build and runs it through the LLBBridge it's handed, the same way a
+// client would if it had computed the LLB itself and called Solver.Solve
+// directly. This lets a build be described as a Dockerfile (dockerfile.v0)
+// or generated on the fly by an arbitrary container image (gateway.v0)
+// without every client linking client/llb.
+type Frontend interface {
+	Solve(ctx context.Context, llb LLBBridge, opt map[string]string) (*Result, error)
+}
+
+// LLBBridge is the callback surface a Frontend uses to run an LLB subgraph
+// against the Solver that invoked it, instead of starting a disconnected,
+// nested build of its own.
+type LLBBridge interface {
+	// Solve resolves a marshaled LLB definition (see (*client/llb.State).Marshal)
+	// against the running Solver and returns its final vertex's result.
+	Solve(ctx context.Context, def [][]byte) (*Result, error)
+	// ResolveImageConfig resolves ref's image config - entrypoint, cmd, env,
+	// user, ... - without pulling the full image. Frontends use this to seed
+	// defaults from a FROM image the way the real docker build does.
+	ResolveImageConfig(ctx context.Context, ref string) ([]byte, error)
+}
+
+// Result is what a Frontend hands back: the ref to export, plus whatever
+// metadata it wants attached to it (e.g. the "env/<key>" entries
+// dockerfile.v0 records from ENV instructions).
+type Result struct {
+	Ref      cache.ImmutableRef
+	Metadata map[string][]byte
+}
@@ -0,0 +1,50 @@
+package gateway
+
+import (
+	"github.com/moby/buildkit/client/llb"
+	"github.com/moby/buildkit/frontend"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// Build is the frontend ID for a gateway-driven build: instead of shipping
+// LLB (or a format this daemon already knows how to turn into LLB, like a
+// Dockerfile), the client points at a container image that computes the
+// LLB itself and calls back into the daemon for it over the same
+// LLBBridge protocol the daemon's own gRPC frontend exposes.
+const Build = "gateway.v0"
+
+// keySource is the FrontendAttrs key naming the builder image to run.
+const keySource = "source"
+
+// Frontend pulls FrontendOpt["source"] and, once the LLBBridge protocol is
+// exposed to a running container over grpc, execs it and lets it drive the
+// build.
+//
+// TODO: only the "pull the builder image" half is implemented. Exposing
+// LLBBridge.Solve/ResolveImageConfig to the running container - so it can
+// request sub-builds instead of just running to completion - needs a grpc
+// service definition that isn't part of this tree yet.
+type Frontend struct{}
+
+func New() *Frontend {
+	return &Frontend{}
+}
+
+func (f *Frontend) Solve(ctx context.Context, bridge frontend.LLBBridge, opt map[string]string) (*frontend.Result, error) {
+	source := opt[keySource]
+	if source == "" {
+		return nil, errors.Errorf("missing %q frontend option", keySource)
+	}
+
+	def, err := llb.Image(source).Marshal()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to marshal gateway source %s", source)
+	}
+
+	if _, err := bridge.Solve(ctx, def); err != nil {
+		return nil, errors.Wrapf(err, "failed to pull gateway source %s", source)
+	}
+
+	return nil, errors.Errorf("gateway.v0: running %s as a build driver over the LLBBridge grpc protocol is not implemented", source)
+}
@@ -0,0 +1,48 @@
+package instructioncache
+
+import (
+	"sync"
+
+	"github.com/moby/buildkit/cache"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+)
+
+// LocalStore is a solver.InstructionCache backed by an in-memory map. It
+// never expires or persists entries on its own, and it only ever lives in
+// the process that populated it; a build that wants its cache to survive a
+// restart or be shared with another machine should use
+// cache/remotecache.Registry instead, keyed by opVertex.remoteCacheKey.
+type LocalStore struct {
+	mu    sync.Mutex
+	byKey map[digest.Digest][]cache.ImmutableRef
+}
+
+func NewLocalStore() *LocalStore {
+	return &LocalStore{byKey: map[digest.Digest][]cache.ImmutableRef{}}
+}
+
+func (s *LocalStore) Lookup(ctx context.Context, key digest.Digest) ([]cache.ImmutableRef, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	refs, ok := s.byKey[key]
+	if !ok {
+		return nil, nil
+	}
+	out := make([]cache.ImmutableRef, len(refs))
+	for i, r := range refs {
+		out[i] = r.Clone()
+	}
+	return out, nil
+}
+
+func (s *LocalStore) Set(ctx context.Context, key digest.Digest, refs []cache.ImmutableRef) error {
+	cloned := make([]cache.ImmutableRef, len(refs))
+	for i, r := range refs {
+		cloned[i] = r.Clone()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.byKey[key] = cloned
+	return nil
+}
@@ -0,0 +1,22 @@
+package session
+
+import "context"
+
+type contextKeyT string
+
+var contextKey = contextKeyT("buildkit/session")
+
+// WithGroup attaches g to ctx so solver code several calls deep (the exec
+// op's secret/ssh resolution, the image source's registry auth) can reach
+// back into the client without threading a Group through every signature in
+// between.
+func WithGroup(ctx context.Context, g Group) context.Context {
+	return context.WithValue(ctx, contextKey, g)
+}
+
+// GroupFromContext returns the Group attached by WithGroup, or nil if none
+// was attached (e.g. a build started without a client session).
+func GroupFromContext(ctx context.Context) Group {
+	g, _ := ctx.Value(contextKey).(Group)
+	return g
+}
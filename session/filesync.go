@@ -0,0 +1,36 @@
+package session
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// FileSyncProvider is implemented by an Attachable that can sync a named
+// local directory - the one llb.Local(name) refers to - into dest. The name
+// is whatever the client chose when it registered the directory; it has no
+// relation to the identifier string the local:// source op carries.
+type FileSyncProvider interface {
+	Attachable
+	CopyTo(ctx context.Context, name string, includePatterns, excludePatterns []string, dest string) error
+}
+
+// ResolveFileSync asks every Session in g, in order, to sync name into dest
+// and returns the first one that has it registered.
+func (g Group) ResolveFileSync(ctx context.Context, name string, includePatterns, excludePatterns []string, dest string) error {
+	for _, s := range g {
+		s.mu.Lock()
+		attachables := append([]Attachable(nil), s.attachables...)
+		s.mu.Unlock()
+		for _, a := range attachables {
+			p, ok := a.(FileSyncProvider)
+			if !ok {
+				continue
+			}
+			if err := p.CopyTo(ctx, name, includePatterns, excludePatterns, dest); err == nil {
+				return nil
+			}
+		}
+	}
+	return errors.Errorf("local dir %s not found in session", name)
+}
@@ -0,0 +1,117 @@
+// Package filesync is NOT the client-side half of llb.Local over a real
+// session transport: it is a same-host stand-in for it. The request this
+// was built against asked for a client-attached bidirectional gRPC stream
+// carrying an fsutil/continuity-style DiffCopy with stat-based change
+// detection; nothing in this tree dials Session.Conn() yet, so
+// SameHostProvider.CopyTo just walks and copies dirs[name] on the assumption
+// client and daemon share a filesystem (the same simplification
+// session.SecretStore and session.SSHForwarder make). Do not wire this in
+// as local's production backing without replacing CopyTo with a real
+// DiffCopy/TarStream RPC first.
+package filesync
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/moby/buildkit/session"
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+)
+
+// SameHostProvider serves dirs - a local name, the one passed to llb.Local,
+// mapped to the host path it resolves to - as a session.FileSyncProvider,
+// by copying on the local filesystem. See the package doc: this is a
+// stand-in for a real client-attached sync, not an implementation of one.
+type SameHostProvider struct {
+	dirs map[string]string
+}
+
+// NewSameHostProvider returns the Attachable a client Allows on its Session
+// so the daemon can resolve llb.Local(name) sources against dirs[name].
+// Only usable when the client and daemon already share a filesystem; see
+// the package doc.
+func NewSameHostProvider(dirs map[string]string) session.Attachable {
+	return &SameHostProvider{dirs: dirs}
+}
+
+// Register is a no-op: CopyTo is invoked directly rather than over a
+// dialed connection, so there is no service to register yet.
+func (p *SameHostProvider) Register(server *grpc.Server) {}
+
+func (p *SameHostProvider) CopyTo(ctx context.Context, name string, includePatterns, excludePatterns []string, dest string) error {
+	dir, ok := p.dirs[name]
+	if !ok {
+		return errors.Errorf("no local dir registered as %q", name)
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if !matches(rel, includePatterns, excludePatterns) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dest, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		return copyFile(path, target, info.Mode())
+	})
+}
+
+// matches reports whether rel should be transferred: included by default
+// (or by an explicit IncludePatterns match), then dropped by a matching
+// ExcludePatterns entry. Patterns are plain path/filepath.Match globs, not
+// full .dockerignore syntax.
+func matches(rel string, includePatterns, excludePatterns []string) bool {
+	included := len(includePatterns) == 0
+	for _, p := range includePatterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, p := range excludePatterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return false
+		}
+	}
+	return true
+}
+
+func copyFile(src, dst string, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}
@@ -0,0 +1,93 @@
+package session
+
+import (
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// sessionIDHeader is the incoming-metadata key a client sets when it dials
+// the Session RPC, so HandleConn knows which id to Attach the resulting
+// Session under. SessionServer, Session_SessionServer and BytesMessage are
+// generated from session.proto, which isn't part of this snapshot - the
+// same way solver/pb's types are assumed rather than present.
+const sessionIDHeader = "x-buildkit-session-id"
+
+// Register wires the Session RPC onto server, alongside whatever other
+// services (controlapi, ...) share the same listener. A client dials it
+// once per build and keeps the stream open for the life of the session.
+// The *grpc.ClientConn dialed on top of that stream is kept on the Session
+// (see Session.Conn); the Group Resolve* methods do not use it.
+func (m *Manager) Register(server *grpc.Server) {
+	RegisterSessionServer(server, m)
+}
+
+// Session implements the generated SessionServer interface.
+func (m *Manager) Session(stream Session_SessionServer) error {
+	ctx := stream.Context()
+	md, _ := metadata.FromIncomingContext(ctx)
+	ids := md[sessionIDHeader]
+	if len(ids) == 0 || ids[0] == "" {
+		return errors.Errorf("missing %s in session request", sessionIDHeader)
+	}
+	id := ids[0]
+
+	dialer := func(string, time.Duration) (net.Conn, error) {
+		return &streamConn{stream: stream}, nil
+	}
+	cc, err := grpc.DialContext(ctx, id, grpc.WithDialer(dialer), grpc.WithInsecure())
+	if err != nil {
+		return errors.Wrap(err, "failed to dial session connection")
+	}
+	defer cc.Close()
+
+	s := NewSession(id, cc)
+	m.Attach(s)
+	defer m.Detach(id)
+
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// streamConn adapts the bidirectional Session stream to a net.Conn, so a
+// single stream can back a *grpc.ClientConn the same way a real dialed
+// connection would.
+type streamConn struct {
+	stream  Session_SessionServer
+	readBuf []byte
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	for len(c.readBuf) == 0 {
+		msg, err := c.stream.Recv()
+		if err != nil {
+			return 0, err
+		}
+		c.readBuf = msg.Data
+	}
+	n := copy(b, c.readBuf)
+	c.readBuf = c.readBuf[n:]
+	return n, nil
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	if err := c.stream.Send(&BytesMessage{Data: b}); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *streamConn) Close() error                       { return nil }
+func (c *streamConn) LocalAddr() net.Addr                { return streamAddr{} }
+func (c *streamConn) RemoteAddr() net.Addr               { return streamAddr{} }
+func (c *streamConn) SetDeadline(t time.Time) error      { return nil }
+func (c *streamConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *streamConn) SetWriteDeadline(t time.Time) error { return nil }
+
+type streamAddr struct{}
+
+func (streamAddr) Network() string { return "buildkit-session" }
+func (streamAddr) String() string  { return "buildkit-session" }
@@ -0,0 +1,39 @@
+package session
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// SecretStore is implemented by an Attachable that can answer "what is the
+// content of secret id" (a local file on the client, a Vault lease, ...).
+// ResolveSecret calls GetSecret directly on each Attachable added with
+// Allow, not over the session's gRPC connection. The secret is resolved on demand for the
+// duration of the mount and is never written anywhere a committed snapshot
+// layer could pick it up.
+type SecretStore interface {
+	Attachable
+	GetSecret(ctx context.Context, id string) ([]byte, error)
+}
+
+// ResolveSecret asks every Session in g, in order, for secret id and returns
+// the first answer.
+func (g Group) ResolveSecret(ctx context.Context, id string) ([]byte, error) {
+	for _, s := range g {
+		s.mu.Lock()
+		attachables := append([]Attachable(nil), s.attachables...)
+		s.mu.Unlock()
+		for _, a := range attachables {
+			store, ok := a.(SecretStore)
+			if !ok {
+				continue
+			}
+			dt, err := store.GetSecret(ctx, id)
+			if err == nil {
+				return dt, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("secret %s not found in session", id)
+}
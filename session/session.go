@@ -0,0 +1,88 @@
+package session
+
+import (
+	"sync"
+
+	"google.golang.org/grpc"
+)
+
+// Attachable lets a caller register additional gRPC services onto a Session
+// without buildkit itself knowing about them, e.g. a Vault-backed secret
+// store, the aws-sdk credential provider, or ssh-agent forwarding.
+type Attachable interface {
+	Register(*grpc.Server)
+}
+
+// Session is one client's side-channel connection back into buildkitd. The
+// solver looks a Session up by the build's own id, and build ids are unique
+// while a build runs, so one Session serves one build at a time; concurrent
+// builds are not multiplexed over it.
+type Session struct {
+	id   string
+	conn *grpc.ClientConn
+
+	mu          sync.Mutex
+	attachables []Attachable
+}
+
+func NewSession(id string, conn *grpc.ClientConn) *Session {
+	return &Session{id: id, conn: conn}
+}
+
+func (s *Session) ID() string { return s.id }
+
+func (s *Session) Conn() *grpc.ClientConn { return s.conn }
+
+// Allow adds a to the Attachables that Group's Resolve* methods consult.
+// Those methods call a's Go methods directly; a's Register is not called, so
+// none of its RPCs are served over this session's connection.
+func (s *Session) Allow(a Attachable) {
+	s.mu.Lock()
+	s.attachables = append(s.attachables, a)
+	s.mu.Unlock()
+}
+
+// Manager tracks every Session currently attached to the daemon, keyed by
+// the ID the client picked when it dialed in.
+type Manager struct {
+	mu       sync.Mutex
+	sessions map[string]*Session
+}
+
+func NewManager() *Manager {
+	return &Manager{sessions: map[string]*Session{}}
+}
+
+// Attach registers a session, making it resolvable by Group for as long as
+// the client keeps its connection open.
+func (m *Manager) Attach(s *Session) {
+	m.mu.Lock()
+	m.sessions[s.id] = s
+	m.mu.Unlock()
+}
+
+func (m *Manager) Detach(id string) {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.mu.Unlock()
+}
+
+// Group is the set of Sessions a build is allowed to dial back into to
+// resolve secrets, ssh forwarding, or private-registry auth. It is a slice
+// rather than a single Session so a gateway frontend can later fan a client
+// build out across several of its own sub-builds without losing access to
+// the originating session.
+type Group []*Session
+
+// Group looks up the Session registered under id. It returns a nil Group
+// (not an error) when nothing is attached under that id, since builds that
+// don't need secrets/ssh/private images are free to proceed without one.
+func (m *Manager) Group(id string) Group {
+	m.mu.Lock()
+	s, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok {
+		return nil
+	}
+	return Group{s}
+}
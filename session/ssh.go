@@ -0,0 +1,37 @@
+package session
+
+import (
+	"context"
+	"net"
+
+	"github.com/pkg/errors"
+)
+
+// SSHForwarder is implemented by an Attachable that can open a connection to
+// the client's ssh-agent (or an explicit identity) on demand, so a build
+// step can use it without the private key ever touching the daemon's disk.
+type SSHForwarder interface {
+	Attachable
+	Dial(ctx context.Context, id string) (net.Conn, error)
+}
+
+// ResolveSSH asks every Session in g, in order, to dial the ssh-agent
+// forward registered under id and returns the first live connection.
+func (g Group) ResolveSSH(ctx context.Context, id string) (net.Conn, error) {
+	for _, s := range g {
+		s.mu.Lock()
+		attachables := append([]Attachable(nil), s.attachables...)
+		s.mu.Unlock()
+		for _, a := range attachables {
+			fwd, ok := a.(SSHForwarder)
+			if !ok {
+				continue
+			}
+			conn, err := fwd.Dial(ctx, id)
+			if err == nil {
+				return conn, nil
+			}
+		}
+	}
+	return nil, errors.Errorf("ssh agent %s not found in session", id)
+}
@@ -0,0 +1,106 @@
+package solver
+
+import (
+	"github.com/moby/buildkit/frontend"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/solver/pb"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+	"golang.org/x/net/context"
+)
+
+// SolveWithFrontend runs frontendName (looked up in s.opt.Frontends) instead
+// of a pre-built LLB graph: the frontend computes whatever LLB the build
+// needs and runs it back through the bridge, the same way a client would
+// have if it had computed that LLB itself and called Solve directly.
+func (s *Solver) SolveWithFrontend(ctx context.Context, id string, frontendName string, frontendOpt map[string]string) error {
+	f, ok := s.opt.Frontends[frontendName]
+	if !ok {
+		return errors.Errorf("unknown frontend %s", frontendName)
+	}
+	bridge := &llbBridge{s: s, id: id}
+	_, err := f.Solve(ctx, bridge, frontendOpt)
+	return err
+}
+
+// llbBridge is the frontend.LLBBridge a Frontend gets handed by
+// SolveWithFrontend: it lets the frontend resolve an LLB subgraph against
+// this very Solver instead of running a disconnected, nested build.
+type llbBridge struct {
+	s  *Solver
+	id string
+}
+
+func (b *llbBridge) Solve(ctx context.Context, def [][]byte) (*frontend.Result, error) {
+	g, err := LoadLLB(def)
+	if err != nil {
+		return nil, err
+	}
+	// Each sub-solve gets its own job id, nested under the frontend's own
+	// id, so it shows up as a distinct vertex tree in Status without losing
+	// track of which build it belongs to.
+	subID := b.id + "/" + identity.NewID()
+	if err := b.s.Solve(ctx, subID, g); err != nil {
+		return nil, err
+	}
+	res := &frontend.Result{}
+	if len(g.refs) > 0 {
+		res.Ref = g.refs[0]
+	}
+	return res, nil
+}
+
+func (b *llbBridge) ResolveImageConfig(ctx context.Context, ref string) ([]byte, error) {
+	// TODO: resolve ref's manifest/config over the registry resolver
+	// without pulling the full image, the same way the image source's own
+	// Pull eventually will for a plain FROM. Needed for dockerfile.v0 to
+	// default ENV/USER/CMD from the base image instead of a blank Meta.
+	return nil, errors.Errorf("ResolveImageConfig not implemented for %s", ref)
+}
+
+// LoadLLB parses a marshaled LLB definition (see (*client/llb.State).Marshal)
+// into the vertex tree Solve expects.
+func LoadLLB(def [][]byte) (*opVertex, error) {
+	ops := make(map[digest.Digest]*pb.Op)
+
+	var lastDgst digest.Digest
+	var lastOp *pb.Op
+
+	for i, dt := range def {
+		var op pb.Op
+		if err := (&op).Unmarshal(dt); err != nil {
+			return nil, errors.Wrap(err, "failed to parse op")
+		}
+		dgst := digest.FromBytes(dt)
+		lastDgst, lastOp = dgst, &op
+		if i != len(def)-1 {
+			ops[dgst] = &op
+		}
+	}
+	if lastOp == nil {
+		return nil, errors.Errorf("invalid LLB definition: no ops")
+	}
+
+	cache := make(map[digest.Digest]*opVertex)
+	return loadVertex(lastDgst, lastOp, ops, cache)
+}
+
+func loadVertex(dgst digest.Digest, op *pb.Op, ops map[digest.Digest]*pb.Op, cache map[digest.Digest]*opVertex) (*opVertex, error) {
+	if v, ok := cache[dgst]; ok {
+		return v, nil
+	}
+	vtx := &opVertex{op: op, dgst: dgst}
+	for _, in := range op.Inputs {
+		inOp, ok := ops[digest.Digest(in.Digest)]
+		if !ok {
+			return nil, errors.Errorf("failed to find input %s", in.Digest)
+		}
+		sub, err := loadVertex(digest.Digest(in.Digest), inOp, ops, cache)
+		if err != nil {
+			return nil, err
+		}
+		vtx.inputs = append(vtx.inputs, sub)
+	}
+	cache[dgst] = vtx
+	return vtx, nil
+}
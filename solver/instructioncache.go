@@ -0,0 +1,39 @@
+package solver
+
+import (
+	"github.com/moby/buildkit/cache"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+)
+
+// InstructionCache maps the content-addressable digest of an op (see
+// opVertex.cacheKey) to the cache.ImmutableRefs that running it produced
+// last time, so opVertex.solve can skip re-running an op it has already
+// executed and reuse the refs instead.
+type InstructionCache interface {
+	Lookup(ctx context.Context, key digest.Digest) ([]cache.ImmutableRef, error)
+	Set(ctx context.Context, key digest.Digest, refs []cache.ImmutableRef) error
+}
+
+// cacheKey computes the key under which g's result should be looked up and
+// stored in the instruction cache. It combines the digest of the op itself
+// (already content-addressable, see LoadLLB) with the resolved ChainID of every
+// input ref, since the same op proto run against different input content
+// must not share a cache entry. Callers must only invoke this once all of
+// g.inputs have been solved.
+func (g *opVertex) cacheKey(ctx context.Context) digest.Digest {
+	dt := []byte(g.dgst)
+	for _, in := range g.inputs {
+		for _, ref := range in.refs {
+			if ref == nil {
+				continue
+			}
+			// For Op_Source this is the resolved image/git content digest;
+			// for Op_Exec output it is the digest of the layer the previous
+			// op committed. Either way it is the piece of information that
+			// isn't already captured by the marshaled op proto.
+			dt = append(dt, []byte(ref.Info().ChainID)...)
+		}
+	}
+	return digest.FromBytes(dt)
+}
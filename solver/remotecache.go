@@ -0,0 +1,50 @@
+package solver
+
+import (
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/source"
+	digest "github.com/opencontainers/go-digest"
+	"golang.org/x/net/context"
+)
+
+// remoteCacheKey computes the key under which g's result should be looked
+// up and stored in the remote cache. Unlike cacheKey, it does not depend on
+// g.inputs having been solved: it is the op's own content digest combined
+// with the recursive remoteCacheKey of every input, so opt.CacheImporter
+// can be consulted - and short-circuit the whole subgraph on a hit -
+// before a single op in it has actually run.
+//
+// For a source op this also folds in the SourceInstance's current
+// GetCacheKey, since the op proto's identifier alone (e.g. a git branch or
+// an image tag) doesn't change even when the content it resolves to does;
+// without it a stale cache entry could outlive the content it was built
+// from.
+func (g *opVertex) remoteCacheKey(ctx context.Context, sm *source.Manager) (digest.Digest, error) {
+	dt := []byte(g.dgst)
+
+	if op, ok := g.op.Op.(*pb.Op_Source); ok && sm != nil {
+		id, err := source.FromString(op.Source.Identifier, op.Source.Attrs)
+		if err != nil {
+			return "", err
+		}
+		inst, err := sm.Resolve(ctx, id, session.GroupFromContext(ctx))
+		if err != nil {
+			return "", err
+		}
+		key, err := inst.GetCacheKey(ctx)
+		if err != nil {
+			return "", err
+		}
+		dt = append(dt, []byte(key)...)
+	}
+
+	for _, in := range g.inputs {
+		key, err := in.remoteCacheKey(ctx, sm)
+		if err != nil {
+			return "", err
+		}
+		dt = append(dt, []byte(key)...)
+	}
+	return digest.FromBytes(dt), nil
+}
@@ -7,23 +7,45 @@ import (
 	"sync"
 	"time"
 
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/cache/remotecache"
+	"github.com/moby/buildkit/client"
+	"github.com/moby/buildkit/frontend"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/solver/pb"
+	"github.com/moby/buildkit/source"
+	"github.com/moby/buildkit/util/progress"
+	"github.com/moby/buildkit/worker"
 	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
-	"github.com/tonistiigi/buildkit_poc/cache"
-	"github.com/tonistiigi/buildkit_poc/client"
-	"github.com/tonistiigi/buildkit_poc/identity"
-	"github.com/tonistiigi/buildkit_poc/solver/pb"
-	"github.com/tonistiigi/buildkit_poc/source"
-	"github.com/tonistiigi/buildkit_poc/util/progress"
-	"github.com/tonistiigi/buildkit_poc/worker"
+	"github.com/sirupsen/logrus"
 	"golang.org/x/net/context"
 	"golang.org/x/sync/errgroup"
 )
 
 type Opt struct {
-	SourceManager *source.Manager
-	CacheManager  cache.Manager // TODO: this shouldn't be needed before instruction cache
-	Worker        worker.Worker
+	SourceManager    *source.Manager
+	CacheManager     cache.Manager
+	InstructionCache InstructionCache // may be nil, in which case every op always runs
+	// CacheImporter and CacheExporter back a shared, cross-machine cache
+	// keyed by opVertex.remoteCacheKey, as opposed to InstructionCache's
+	// single-process, ChainID-keyed one. Either may be nil.
+	CacheImporter  remotecache.Importer
+	CacheExporter  remotecache.Exporter
+	Worker         worker.Worker
+	SessionManager *session.Manager // may be nil, in which case secret/ssh mounts and private image pulls fail
+	// NetworkProviders is looked up by the exec op's requested network
+	// mode; a mode with no entry falls back to worker.NetModeHost so a
+	// worker that was never configured with CNI still runs builds.
+	NetworkProviders map[worker.NetworkMode]worker.NetworkProvider
+	// DNSConfig is the default resolver config exec ops get when their
+	// NetNS doesn't already come with its own, and the op itself doesn't
+	// set an override in its Meta. May be nil.
+	DNSConfig *worker.DNSConfig
+	// Frontends is looked up by name from SolveWithFrontend; a build that
+	// ships raw LLB instead of a frontend name never consults it.
+	Frontends map[string]frontend.Frontend
 }
 
 type Solver struct {
@@ -41,6 +63,14 @@ func (s *Solver) Solve(ctx context.Context, id string, g *opVertex) error {
 
 	pr, ctx, closeProgressWriter := progress.NewContext(ctx)
 
+	if s.opt.SessionManager != nil {
+		// id doubles as the session ID: the build sees the Session attached
+		// under its own id, if any. Job ids are unique (see jobList.new), so
+		// only one build at a time runs under a given Session, and a
+		// frontend's sub-solves, which get ids of their own, see none.
+		ctx = session.WithGroup(ctx, s.opt.SessionManager.Group(id))
+	}
+
 	if len(g.inputs) > 0 { // TODO: detect op_return better
 		g = g.inputs[0]
 	}
@@ -130,6 +160,23 @@ func (g *opVertex) solve(ctx context.Context, opt Opt) (retErr error) {
 	pw, _, ctx := progress.FromContext(ctx, progress.WithMetadata("vertex", g.dgst))
 	defer pw.Close()
 
+	var remoteCacheKey digest.Digest
+	if opt.CacheImporter != nil {
+		key, err := g.remoteCacheKey(ctx, opt.SourceManager)
+		if err != nil {
+			return err
+		}
+		remoteCacheKey = key
+		refs, err := opt.CacheImporter.Import(ctx, opt.CacheManager, remoteCacheKey)
+		if err != nil {
+			return err
+		}
+		if refs != nil {
+			g.refs = refs
+			return nil
+		}
+	}
+
 	if len(g.inputs) > 0 {
 		eg, ctx := errgroup.WithContext(ctx)
 
@@ -149,6 +196,19 @@ func (g *opVertex) solve(ctx context.Context, opt Opt) (retErr error) {
 		}
 	}
 
+	var cacheKey digest.Digest
+	if opt.InstructionCache != nil {
+		cacheKey = g.cacheKey(ctx)
+		refs, err := opt.InstructionCache.Lookup(ctx, cacheKey)
+		if err != nil {
+			return err
+		}
+		if refs != nil {
+			g.refs = refs
+			return nil
+		}
+	}
+
 	g.notifyStarted(ctx)
 	defer g.notifyCompleted(ctx)
 
@@ -158,21 +218,42 @@ func (g *opVertex) solve(ctx context.Context, opt Opt) (retErr error) {
 			return err
 		}
 	case *pb.Op_Exec:
-		if err := g.runExecOp(ctx, opt.CacheManager, opt.Worker, op); err != nil {
+		if err := g.runExecOp(ctx, opt.CacheManager, opt.Worker, opt.NetworkProviders, opt.DNSConfig, op); err != nil {
 			return err
 		}
 	default:
 		return errors.Errorf("invalid op type %T", g.op.Op)
 	}
+
+	if opt.InstructionCache != nil {
+		if err := opt.InstructionCache.Set(ctx, cacheKey, g.refs); err != nil {
+			return err
+		}
+	}
+
+	if opt.CacheExporter != nil {
+		if remoteCacheKey == "" {
+			key, err := g.remoteCacheKey(ctx, opt.SourceManager)
+			if err != nil {
+				return err
+			}
+			remoteCacheKey = key
+		}
+		if err := opt.CacheExporter.Export(ctx, remoteCacheKey, g.refs); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 func (g *opVertex) runSourceOp(ctx context.Context, sm *source.Manager, op *pb.Op_Source) error {
-	id, err := source.FromString(op.Source.Identifier)
+	id, err := source.FromString(op.Source.Identifier, op.Source.Attrs)
 	if err != nil {
 		return err
 	}
-	ref, err := sm.Pull(ctx, id)
+	// Threaded through so a docker-image:// source can exchange the
+	// client's registry auth for a token when pulling a private image.
+	ref, err := sm.Pull(ctx, id, session.GroupFromContext(ctx))
 	if err != nil {
 		return err
 	}
@@ -180,7 +261,7 @@ func (g *opVertex) runSourceOp(ctx context.Context, sm *source.Manager, op *pb.O
 	return nil
 }
 
-func (g *opVertex) runExecOp(ctx context.Context, cm cache.Manager, w worker.Worker, op *pb.Op_Exec) error {
+func (g *opVertex) runExecOp(ctx context.Context, cm cache.Manager, w worker.Worker, netProviders map[worker.NetworkMode]worker.NetworkProvider, dnsConfig *worker.DNSConfig, op *pb.Op_Exec) error {
 	mounts := make(map[string]cache.Mountable)
 
 	var outputs []cache.MutableRef
@@ -196,7 +277,50 @@ func (g *opVertex) runExecOp(ctx context.Context, cm cache.Manager, w worker.Wor
 		}
 	}()
 
+	netMode := networkMode(op)
+	netProvider, ok := netProviders[netMode]
+	if !ok {
+		netProvider = netProviders[worker.NetModeHost]
+	}
+	var netNS worker.NetworkNamespace
+	if netProvider != nil {
+		ns, err := netProvider.New(ctx, g.dgst.String())
+		if err != nil {
+			return errors.Wrapf(err, "failed to provision network for %v", op.Exec.Meta.Args)
+		}
+		netNS = ns
+		// Teardown must run even when the op fails partway through, not
+		// just on the happy path.
+		defer func() {
+			if err := netNS.Close(context.Background()); err != nil {
+				logrus.Errorf("failed to tear down network for %v: %v", op.Exec.Meta.Args, err)
+			}
+		}()
+	}
+
+	sessionGroup := session.GroupFromContext(ctx)
+
+	var secrets []worker.SecretMount
+	var sshMounts []worker.SSHMount
+
 	for _, m := range op.Exec.Mounts {
+		switch m.MountType {
+		case pb.MountType_SECRET:
+			dt, err := sessionGroup.ResolveSecret(ctx, m.SecretOpt.ID)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve secret %s", m.SecretOpt.ID)
+			}
+			secrets = append(secrets, worker.SecretMount{Dest: m.Dest, Data: dt})
+			continue
+		case pb.MountType_SSH:
+			conn, err := sessionGroup.ResolveSSH(ctx, m.SSHOpt.ID)
+			if err != nil {
+				return errors.Wrapf(err, "failed to resolve ssh forward %s", m.SSHOpt.ID)
+			}
+			sshMounts = append(sshMounts, worker.SSHMount{Dest: m.Dest, Conn: conn})
+			continue
+		}
+
 		var mountable cache.Mountable
 		ref := g.getInputRefForIndex(int(m.Input))
 		mountable = ref
@@ -211,10 +335,34 @@ func (g *opVertex) runExecOp(ctx context.Context, cm cache.Manager, w worker.Wor
 		mounts[m.Dest] = mountable
 	}
 
+	// A per-op Dns override (set at marshal time, see client/llb) wins over
+	// the daemon default; NetModeHost already sees the worker's own
+	// resolver, so it never gets a synthesized one either way.
+	dns := dnsConfig
+	if d := op.Exec.Meta.Dns; d != nil {
+		dns = &worker.DNSConfig{
+			Nameservers:   d.Nameservers,
+			SearchDomains: d.SearchDomains,
+			Options:       d.Options,
+		}
+	}
+	var resolvConf string
+	if netMode != worker.NetModeHost {
+		path, err := worker.WriteResolvConf(g.dgst.String(), dns)
+		if err != nil {
+			return errors.Wrap(err, "failed to write resolv.conf")
+		}
+		resolvConf = path
+	}
+
 	meta := worker.Meta{
-		Args: op.Exec.Meta.Args,
-		Env:  op.Exec.Meta.Env,
-		Cwd:  op.Exec.Meta.Cwd,
+		Args:       op.Exec.Meta.Args,
+		Env:        op.Exec.Meta.Env,
+		Cwd:        op.Exec.Meta.Cwd,
+		Secrets:    secrets,
+		SSH:        sshMounts,
+		NetNS:      netNS,
+		ResolvConf: resolvConf,
 	}
 
 	stdout := newStreamWriter(ctx, 1)
@@ -238,6 +386,19 @@ func (g *opVertex) runExecOp(ctx context.Context, cm cache.Manager, w worker.Wor
 	return nil
 }
 
+// networkMode maps the op's requested network mode to the worker constant
+// used to select a NetworkProvider from SolverOpt.NetworkProviders.
+func networkMode(op *pb.Op_Exec) worker.NetworkMode {
+	switch op.Exec.Meta.Network {
+	case pb.NetMode_HOST:
+		return worker.NetModeHost
+	case pb.NetMode_NONE:
+		return worker.NetModeNone
+	default:
+		return worker.NetModeDefault
+	}
+}
+
 func (g *opVertex) notifyStarted(ctx context.Context) {
 	pw, _, _ := progress.FromContext(ctx)
 	defer pw.Close()
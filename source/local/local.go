@@ -0,0 +1,117 @@
+// Package local implements the local:// Source: it syncs a directory from
+// the client's attached session instead of pulling from a registry or git
+// remote, backing llb.Local.
+package local
+
+import (
+	"context"
+
+	"github.com/containerd/containerd/mount"
+	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/identity"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/source"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// ID is both this Source's own ID() and the scheme every Identifier it
+// produces shares, the same way "docker-image" doubles as both for that
+// source.
+const ID = "local"
+
+// Identifier is source.FromString's result for a "local://<name>" ref. Name
+// is the key the client registered its host directory under (see
+// session/filesync.NewSameHostProvider); IncludePatterns/ExcludePatterns
+// come from the matching llb.Local options and narrow the transfer to the
+// subset an op actually needs instead of shipping the whole directory.
+type Identifier struct {
+	Name            string
+	SessionID       string
+	IncludePatterns []string
+	ExcludePatterns []string
+}
+
+func (*Identifier) ID() string { return ID }
+
+func (id *Identifier) String() string { return "local://" + id.Name }
+
+// Source resolves local:// identifiers by syncing straight into a fresh ref
+// instead of fetching into a shared content store, since a local directory
+// has no stable identity to dedup against between builds.
+type Source struct {
+	cm cache.Manager
+}
+
+func NewSource(cm cache.Manager) source.Source {
+	return &Source{cm: cm}
+}
+
+func (ls *Source) ID() string { return ID }
+
+func (ls *Source) Resolve(ctx context.Context, id source.Identifier, sessionGroup session.Group) (source.SourceInstance, error) {
+	local, ok := id.(*Identifier)
+	if !ok {
+		return nil, errors.Errorf("invalid identifier for local source: %T", id)
+	}
+	return &sourceInstance{src: ls, id: local, sessionGroup: sessionGroup}, nil
+}
+
+func (ls *Source) Pull(ctx context.Context, id source.Identifier, sessionGroup session.Group) (cache.ImmutableRef, error) {
+	inst, err := ls.Resolve(ctx, id, sessionGroup)
+	if err != nil {
+		return nil, err
+	}
+	return inst.GetSnapshot(ctx)
+}
+
+type sourceInstance struct {
+	src          *Source
+	id           *Identifier
+	sessionGroup session.Group
+}
+
+// GetCacheKey always reports a miss: unlike a git commit or an image
+// digest, a local directory's content isn't knowable - or addressable -
+// ahead of actually transferring it, so there is nothing cheaper to check
+// here than GetSnapshot itself.
+func (si *sourceInstance) GetCacheKey(ctx context.Context) (digest.Digest, error) {
+	return digest.FromBytes([]byte(identity.NewID())), nil
+}
+
+func (si *sourceInstance) GetSnapshot(ctx context.Context) (cache.ImmutableRef, error) {
+	mref, err := si.src.cm.New(ctx, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to allocate ref for local source")
+	}
+	releaseOnErr := true
+	defer func() {
+		if releaseOnErr {
+			mref.Release(context.Background())
+		}
+	}()
+
+	mountable, err := mref.Mount(ctx, false)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to mount local source checkout")
+	}
+	mounts, unmount, err := mountable.Mount()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get mounts for local source checkout")
+	}
+	defer unmount()
+
+	err = mount.WithTempMount(ctx, mounts, func(root string) error {
+		return si.sessionGroup.ResolveFileSync(ctx, si.id.Name, si.id.IncludePatterns, si.id.ExcludePatterns, root)
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sync local source %s", si.id.Name)
+	}
+
+	ref, err := mref.Commit(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to commit local source checkout")
+	}
+	releaseOnErr = false
+	return ref, nil
+}
@@ -2,30 +2,45 @@ package source
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/moby/buildkit/cache"
+	"github.com/moby/buildkit/session"
+	"github.com/moby/buildkit/util/flightcontrol"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/pkg/errors"
 )
 
 type Source interface {
 	ID() string
-	Pull(ctx context.Context, id Identifier) (cache.ImmutableRef, error)
+	// Pull resolves id to a ref. sessionGroup is nil unless the build that
+	// triggered the pull has an attached client session; a docker-image://
+	// source uses it to exchange the client's registry auth for a pull
+	// token instead of pulling anonymously.
+	Pull(ctx context.Context, id Identifier, sessionGroup session.Group) (cache.ImmutableRef, error)
+	// Resolve binds id to this Source without pulling anything, so its
+	// remote cache key can be read - and a cache hit found - without
+	// paying for GetSnapshot's actual pull/checkout.
+	Resolve(ctx context.Context, id Identifier, sessionGroup session.Group) (SourceInstance, error)
 }
 
-// type Source interface {
-// 	ID() string
-// 	Resolve(ctx context.Context, id Identifier) (SourceInstance, error)
-// }
-//
-// type SourceInstance interface {
-// 	GetCacheKey(ctx context.Context) ([]string, error)
-// 	GetSnapshot(ctx context.Context) (cache.ImmutableRef, error)
-// }
+// SourceInstance is an Identifier bound to the Source that resolved it.
+type SourceInstance interface {
+	// GetCacheKey returns the content digest id currently resolves to (a
+	// git ref's current commit, a tag's current manifest digest, ...)
+	// without fetching anything beyond that metadata.
+	GetCacheKey(ctx context.Context) (digest.Digest, error)
+	// GetSnapshot does the actual pull/checkout and returns it as a ref.
+	GetSnapshot(ctx context.Context) (cache.ImmutableRef, error)
+}
 
 type Manager struct {
 	mu      sync.Mutex
 	sources map[string]Source
+
+	pullG    flightcontrol.Group
+	resolveG flightcontrol.Group
 }
 
 func NewManager() (*Manager, error) {
@@ -40,7 +55,11 @@ func (sm *Manager) Register(src Source) {
 	sm.mu.Unlock()
 }
 
-func (sm *Manager) Pull(ctx context.Context, id Identifier) (cache.ImmutableRef, error) {
+// Pull routes to the Source registered for id.ID() through a flightcontrol
+// group, so that concurrent Pulls of the same identifier (a base image
+// referenced from many stages, say) share one call instead of each opening
+// its own resolver and racing to write identical blobs.
+func (sm *Manager) Pull(ctx context.Context, id Identifier, sessionGroup session.Group) (cache.ImmutableRef, error) {
 	sm.mu.Lock()
 	src, ok := sm.sources[id.ID()]
 	sm.mu.Unlock()
@@ -49,5 +68,46 @@ func (sm *Manager) Pull(ctx context.Context, id Identifier) (cache.ImmutableRef,
 		return nil, errors.Errorf("no handler fro %s", id.ID())
 	}
 
-	return src.Pull(ctx, id)
+	res, err := sm.pullG.Do(ctx, dedupKey(id), func(ctx context.Context) (interface{}, error) {
+		return src.Pull(ctx, id, sessionGroup)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(cache.ImmutableRef), nil
+}
+
+// Resolve is Pull's single-flight counterpart for looking up a remote
+// cache key without pulling anything.
+func (sm *Manager) Resolve(ctx context.Context, id Identifier, sessionGroup session.Group) (SourceInstance, error) {
+	sm.mu.Lock()
+	src, ok := sm.sources[id.ID()]
+	sm.mu.Unlock()
+
+	if !ok {
+		return nil, errors.Errorf("no handler fro %s", id.ID())
+	}
+
+	res, err := sm.resolveG.Do(ctx, dedupKey(id), func(ctx context.Context) (interface{}, error) {
+		return src.Resolve(ctx, id, sessionGroup)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res.(SourceInstance), nil
+}
+
+// dedupKey is the flightcontrol key for id: id.ID() alone is the scheme
+// ("docker-image", "git", ...), the same for every identifier a given
+// Source handles, so it would collapse e.g. every docker-image pull into
+// one call regardless of ref. Every concrete Identifier in this tree also
+// implements fmt.Stringer (FromString round-trips through it), so folding
+// that in recovers a key specific to this identifier; a hypothetical one
+// that doesn't falls back to sharing id.ID()'s scope, same as today.
+func dedupKey(id Identifier) string {
+	key := id.ID()
+	if s, ok := id.(fmt.Stringer); ok {
+		key += "/" + s.String()
+	}
+	return key
 }
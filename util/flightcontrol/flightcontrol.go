@@ -0,0 +1,82 @@
+package flightcontrol
+
+import (
+	"context"
+	"sync"
+)
+
+// Group deduplicates concurrent calls that share the same key: only the
+// first Do for a key actually runs fn; every other caller for that key
+// blocks on the same in-flight call and gets back its result (or error)
+// once it completes, instead of repeating whatever fn does itself.
+//
+// The zero value is ready to use.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	ctx    context.Context
+	cancel func()
+	ready  chan struct{}
+	refs   int
+
+	result interface{}
+	err    error
+}
+
+// Do runs fn under key, or waits for and returns the result of an already
+// running call under the same key. fn is given its own context, derived
+// from context.Background() rather than ctx, so one waiter giving up
+// doesn't cancel the call for every other waiter still waiting on it; fn's
+// context is only canceled once every waiter has gone.
+func (g *Group) Do(ctx context.Context, key string, fn func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*call)
+	}
+	c, ok := g.calls[key]
+	if !ok {
+		callCtx, cancel := context.WithCancel(context.Background())
+		c = &call{ctx: callCtx, cancel: cancel, ready: make(chan struct{})}
+		g.calls[key] = c
+
+		go func() {
+			c.result, c.err = fn(c.ctx)
+			close(c.ready)
+
+			g.mu.Lock()
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+		}()
+	}
+	c.refs++
+	g.mu.Unlock()
+
+	defer func() {
+		g.mu.Lock()
+		c.refs--
+		if c.refs == 0 {
+			c.cancel()
+			// Remove the entry as soon as the last waiter leaves, not just
+			// when fn's own goroutine gets around to it below: otherwise a
+			// new Do(ctx, key, ...) arriving in that window would attach to
+			// c, whose ctx is already cancelled, and get back a result
+			// produced under a dead context instead of starting a fresh call.
+			if g.calls[key] == c {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+	}()
+
+	select {
+	case <-c.ready:
+		return c.result, c.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,107 @@
+package flightcontrol
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDoDedupsConcurrentCalls(t *testing.T) {
+	var g Group
+	var calls int32
+
+	started := make(chan struct{})
+	unblock := make(chan struct{})
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		close(started)
+		<-unblock
+		return "result", nil
+	}
+
+	results := make(chan interface{}, 2)
+	go func() {
+		v, err := g.Do(context.Background(), "key", fn)
+		assert.NoError(t, err)
+		results <- v
+	}()
+
+	<-started
+	go func() {
+		v, err := g.Do(context.Background(), "key", fn)
+		assert.NoError(t, err)
+		results <- v
+	}()
+
+	// Give the second Do a moment to attach to the in-flight call before
+	// letting fn return, so it dedups instead of racing fn's own cleanup.
+	time.Sleep(20 * time.Millisecond)
+	close(unblock)
+	assert.Equal(t, "result", <-results)
+	assert.Equal(t, "result", <-results)
+	assert.EqualValues(t, 1, atomic.LoadInt32(&calls))
+}
+
+func TestDoRunsAgainAfterPriorCallCompletes(t *testing.T) {
+	var g Group
+	var calls int32
+
+	fn := func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return nil, nil
+	}
+
+	_, err := g.Do(context.Background(), "key", fn)
+	assert.NoError(t, err)
+	_, err = g.Do(context.Background(), "key", fn)
+	assert.NoError(t, err)
+
+	assert.EqualValues(t, 2, atomic.LoadInt32(&calls))
+}
+
+// TestDoAbandonedCallDoesNotStickAround reproduces the window where every
+// waiter's ctx is done before fn returns: once refs drops to 0 the call
+// must be removed from g.calls immediately, not only once fn's goroutine
+// gets around to it, or a new Do for the same key can attach to an already
+// cancelled call and get back a result produced under a dead context.
+func TestDoAbandonedCallDoesNotStickAround(t *testing.T) {
+	var g Group
+	unblock := make(chan struct{})
+
+	fnStarted := make(chan struct{})
+	fn := func(ctx context.Context) (interface{}, error) {
+		close(fnStarted)
+		<-unblock
+		return "stale", nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		_, err := g.Do(ctx, "key", fn)
+		assert.Equal(t, context.Canceled, err)
+		close(done)
+	}()
+
+	<-fnStarted
+	cancel()
+	<-done
+
+	close(unblock)
+	// Give the abandoned fn goroutine a moment to return and try (and fail,
+	// since the entry should already be gone) to delete g.calls["key"].
+	time.Sleep(10 * time.Millisecond)
+
+	var calls int32
+	v, err := g.Do(context.Background(), "key", func(ctx context.Context) (interface{}, error) {
+		atomic.AddInt32(&calls, 1)
+		return "fresh", nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "fresh", v)
+	assert.EqualValues(t, 1, calls)
+}
@@ -6,6 +6,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/moby/buildkit/identity"
 	"github.com/pkg/errors"
 )
 
@@ -13,45 +14,70 @@ type contextKeyT string
 
 var contextKey = contextKeyT("buildkit/util/progress")
 
-func FromContext(ctx context.Context, name string) (ProgressWriter, bool, context.Context) {
-	pw, ok := ctx.Value(contextKey).(*progressWriter)
+func FromContext(ctx context.Context, opts ...WriterOpt) (Writer, bool, context.Context) {
+	parent, ok := ctx.Value(contextKey).(*progressWriter)
 	if !ok {
 		return &noOpWriter{}, false, ctx
 	}
-	pw = newWriter(pw, name)
+	pw := newWriter(parent)
+	for _, o := range opts {
+		o(pw)
+	}
 	ctx = context.WithValue(ctx, contextKey, pw)
 	return pw, false, ctx
 }
 
-func NewContext(ctx context.Context) (ProgressReader, context.Context, func()) {
+func NewContext(ctx context.Context) (Reader, context.Context, func()) {
 	pr, pw, cancel := pipe()
 	ctx = context.WithValue(ctx, contextKey, pw)
 	return pr, ctx, cancel
 }
 
-type ProgressWriter interface {
-	Write(Progress) error
-	Done() error
+// WriterOpt configures a Writer at the point it is derived from a context.
+type WriterOpt func(*progressWriter)
+
+// WithMetadata attaches a key/value pair that is stamped onto every record
+// the writer produces, so a subscriber can tell which vertex a stream of
+// updates belongs to without having to parse IDs.
+func WithMetadata(key string, value interface{}) WriterOpt {
+	return func(pw *progressWriter) {
+		pw.metadata[key] = value
+	}
 }
 
-type ProgressReader interface {
-	Read(context.Context) (*Progress, error)
+type Writer interface {
+	Write(id string, value interface{}) error
+	Close() error
 }
 
-type Progress struct {
-	ID string
+type Reader interface {
+	Read(context.Context) (*Progress, error)
+}
 
-	// Progress contains a Message or...
-	Message string
+// ProgressWriter and ProgressReader are kept as aliases for the earlier,
+// single-writer terminology that callers outside this package still use.
+type ProgressWriter = Writer
+type ProgressReader = Reader
 
-	// ...progress of an action
-	Action    string
-	Current   int
-	Total     int
+// Progress is one update in the build's event stream. UUID/Parent identify
+// the progressWriter that produced it (and that writer's parent), so a
+// subscriber can reconstruct the vertex tree (source pull -> exec -> export)
+// instead of seeing a flat, unrelated stream of updates. This is the real
+// form of what used to be sketched out as ProgressRecord.
+type Progress struct {
+	ID        string
+	UUID      string
+	Parent    string
 	Timestamp time.Time
+	Sys       interface{}
+	Metadata  map[string]interface{}
 	Done      bool
 }
 
+// ProgressRecord is an alias kept for readability at call sites that care
+// specifically about the writer-hierarchy fields rather than the payload.
+type ProgressRecord = Progress
+
 type progressReader struct {
 	ctx     context.Context
 	cond    *sync.Cond
@@ -141,74 +167,216 @@ func pipe() (*progressReader, *progressWriter, func()) {
 		pr.cond.Broadcast()
 	}()
 	pw := &progressWriter{
-		reader: pr,
+		reader:   pr,
+		metadata: map[string]interface{}{},
 	}
 	return pr, pw, cancel
 }
 
-func newWriter(pw *progressWriter, name string) *progressWriter {
-	if pw.id != "" {
-		name = pw.id + "." + name
+func newWriter(parent *progressWriter) *progressWriter {
+	md := make(map[string]interface{}, len(parent.metadata))
+	for k, v := range parent.metadata {
+		md[k] = v
 	}
-	pw = &progressWriter{
-		id:     name,
-		reader: pw.reader,
+	pw := &progressWriter{
+		uuid:     identity.NewID(),
+		parent:   parent.uuid,
+		reader:   parent.reader,
+		metadata: md,
 	}
 	pw.reader.append(pw)
 	return pw
 }
 
 type progressWriter struct {
-	id     string
-	lastP  atomic.Value
-	done   bool
-	reader *progressReader
+	uuid     string
+	parent   string
+	metadata map[string]interface{}
+	lastP    atomic.Value
+	done     bool
+	reader   *progressReader
 }
 
-func (pw *progressWriter) Write(p Progress) error {
+func (pw *progressWriter) Write(id string, value interface{}) error {
 	if pw.done {
-		return errors.Errorf("writing to closed progresswriter %s", pw.id)
-	}
-	p.ID = pw.id
-	if p.Timestamp.IsZero() {
-		p.Timestamp = time.Now()
+		return errors.Errorf("writing to closed progress writer %s", pw.uuid)
 	}
-	pw.lastP.Store(&p)
-	if p.Done {
-		pw.done = true
+	p := &Progress{
+		ID:        id,
+		UUID:      pw.uuid,
+		Parent:    pw.parent,
+		Timestamp: time.Now(),
+		Sys:       value,
+		Metadata:  pw.metadata,
 	}
+	pw.lastP.Store(p)
 	pw.reader.cond.Broadcast()
 	return nil
 }
 
-func (pw *progressWriter) Done() error {
-	var p Progress
-	lastP := pw.lastP.Load().(*Progress)
+func (pw *progressWriter) Close() error {
+	if pw.done {
+		return nil
+	}
+	pw.done = true
+	lastP, _ := pw.lastP.Load().(*Progress)
+	p := Progress{UUID: pw.uuid, Parent: pw.parent, Metadata: pw.metadata}
 	if lastP != nil {
 		p = *lastP
-		if p.Done {
-			return nil
-		}
-	} else {
-		p = Progress{}
 	}
 	p.Done = true
-	return pw.Write(p)
+	p.Timestamp = time.Now()
+	pw.lastP.Store(&p)
+	pw.reader.cond.Broadcast()
+	return nil
 }
 
 type noOpWriter struct{}
 
-func (pw *noOpWriter) Write(p Progress) error {
+func (pw *noOpWriter) Write(id string, value interface{}) error {
 	return nil
 }
 
-func (pw *noOpWriter) Done() error {
+func (pw *noOpWriter) Close() error {
 	return nil
 }
 
-// type ProgressRecord struct {
-// 	UUID   string
-// 	Parent string
-// 	Done   bool
-// 	*Progress
-// }
+// MultiReader fans a single Reader out to any number of independent
+// subscribers, so the CLI, an API stream and the metrics exporter can all
+// observe the same build concurrently without racing over Read. A
+// subscriber that attaches mid-build is replayed the latest known record for
+// every still-open ID before it starts seeing live updates.
+type MultiReader struct {
+	mu      sync.Mutex
+	pr      Reader
+	subs    map[*subReader]struct{}
+	last    map[string]*Progress
+	started bool
+}
+
+func NewMultiReader(pr Reader) *MultiReader {
+	return &MultiReader{
+		pr:   pr,
+		subs: make(map[*subReader]struct{}),
+		last: make(map[string]*Progress),
+	}
+}
+
+// Reader returns a new independent subscriber. It stops producing once ctx
+// is cancelled or the underlying build finishes, whichever comes first.
+func (mr *MultiReader) Reader(ctx context.Context) Reader {
+	mr.mu.Lock()
+	sr := newSubReader()
+	for _, p := range mr.last {
+		if !p.Done {
+			sr.push(p)
+		}
+	}
+	mr.subs[sr] = struct{}{}
+	if !mr.started {
+		mr.started = true
+		go mr.run()
+	}
+	mr.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		mr.mu.Lock()
+		delete(mr.subs, sr)
+		mr.mu.Unlock()
+		sr.close()
+	}()
+
+	return sr
+}
+
+func (mr *MultiReader) run() {
+	ctx := context.Background() // lifetime is governed by pr itself returning nil, nil on completion
+	for {
+		p, err := mr.pr.Read(ctx)
+		if err != nil || p == nil {
+			mr.mu.Lock()
+			for sr := range mr.subs {
+				sr.close()
+			}
+			mr.mu.Unlock()
+			return
+		}
+		mr.mu.Lock()
+		mr.last[p.ID] = p
+		for sr := range mr.subs {
+			sr.push(p)
+		}
+		mr.mu.Unlock()
+	}
+}
+
+// subReader buffers at most one pending record per ID: a slow subscriber
+// that falls behind only ever has stale state for a given ID, it can never
+// build an unbounded backlog that would stall the build producing updates.
+type subReader struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buffer []*Progress
+	closed bool
+}
+
+func newSubReader() *subReader {
+	sr := &subReader{}
+	sr.cond = sync.NewCond(&sr.mu)
+	return sr
+}
+
+func (sr *subReader) push(p *Progress) {
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	if sr.closed {
+		return
+	}
+	for i, q := range sr.buffer {
+		if q.ID == p.ID {
+			sr.buffer[i] = p
+			sr.cond.Broadcast()
+			return
+		}
+	}
+	sr.buffer = append(sr.buffer, p)
+	sr.cond.Broadcast()
+}
+
+func (sr *subReader) close() {
+	sr.mu.Lock()
+	sr.closed = true
+	sr.cond.Broadcast()
+	sr.mu.Unlock()
+}
+
+func (sr *subReader) Read(ctx context.Context) (*Progress, error) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			sr.cond.Broadcast()
+		}
+	}()
+	sr.mu.Lock()
+	defer sr.mu.Unlock()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+		if len(sr.buffer) > 0 {
+			p := sr.buffer[0]
+			sr.buffer = sr.buffer[1:]
+			return p, nil
+		}
+		if sr.closed {
+			return nil, nil
+		}
+		sr.cond.Wait()
+	}
+}
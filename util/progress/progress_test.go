@@ -0,0 +1,93 @@
+package progress
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterReader(t *testing.T) {
+	pr, ctx, cancel := NewContext(context.Background())
+	defer cancel()
+
+	pw, _, _ := FromContext(ctx)
+	assert.NoError(t, pw.Write("foo", "bar"))
+	assert.NoError(t, pw.Close())
+
+	p, err := pr.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", p.ID)
+	assert.Equal(t, "bar", p.Sys)
+	assert.True(t, p.Done)
+}
+
+func TestWriteAfterCloseFails(t *testing.T) {
+	_, ctx, cancel := NewContext(context.Background())
+	defer cancel()
+
+	pw, _, _ := FromContext(ctx)
+	assert.NoError(t, pw.Close())
+	assert.Error(t, pw.Write("foo", "bar"))
+}
+
+func TestFromContextWithoutWriterReturnsNoOp(t *testing.T) {
+	pw, ok, _ := FromContext(context.Background())
+	assert.False(t, ok)
+	assert.NoError(t, pw.Write("foo", "bar"))
+	assert.NoError(t, pw.Close())
+}
+
+func TestMultiReaderFansOutToEverySubscriber(t *testing.T) {
+	pr, ctx, cancel := NewContext(context.Background())
+	defer cancel()
+	mr := NewMultiReader(pr)
+
+	subCtx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	subCtx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	sub1 := mr.Reader(subCtx1)
+	sub2 := mr.Reader(subCtx2)
+
+	pw, _, _ := FromContext(ctx)
+	assert.NoError(t, pw.Write("foo", "bar"))
+
+	p1, err := sub1.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", p1.ID)
+
+	p2, err := sub2.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", p2.ID)
+}
+
+func TestMultiReaderReplaysOpenRecordsToLateSubscriber(t *testing.T) {
+	pr, ctx, cancel := NewContext(context.Background())
+	defer cancel()
+	mr := NewMultiReader(pr)
+
+	subCtx1, cancel1 := context.WithCancel(context.Background())
+	defer cancel1()
+	sub1 := mr.Reader(subCtx1)
+
+	pw, _, _ := FromContext(ctx)
+	assert.NoError(t, pw.Write("foo", "bar")) // left open (never Closed)
+
+	p, err := sub1.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", p.ID)
+
+	// A subscriber attaching after the write, with no further write, should
+	// still see foo: MultiReader.Reader replays every not-yet-Done record
+	// from mr.last instead of only forwarding live updates.
+	time.Sleep(20 * time.Millisecond)
+	subCtx2, cancel2 := context.WithCancel(context.Background())
+	defer cancel2()
+	sub2 := mr.Reader(subCtx2)
+
+	p2, err := sub2.Read(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, "foo", p2.ID)
+}
@@ -0,0 +1,161 @@
+package network
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+
+	"github.com/containernetworking/cni/libcni"
+	cnitypes "github.com/containernetworking/cni/pkg/types"
+	current "github.com/containernetworking/cni/pkg/types/current"
+	"github.com/moby/buildkit/worker"
+	"github.com/pkg/errors"
+)
+
+// defaultConfigDir is where buildkitd looks for CNI plugin config files
+// (*.conf / *.conflist) unless NewCNIProvider is given one explicitly.
+const defaultConfigDir = "/etc/buildkit/cni"
+
+const defaultBinDir = "/opt/cni/bin"
+
+const cniIfName = "eth0"
+
+// CNIProvider provisions a dedicated network namespace per exec op and
+// wires it up by running every CNI plugin config found in ConfigDir, in
+// file order, the same way kubelet wires up a pod sandbox.
+type CNIProvider struct {
+	ConfigDir string
+	BinDir    string
+
+	initOnce sync.Once
+	initErr  error
+	cni      *libcni.CNIConfig
+	networks []*libcni.NetworkConfigList
+}
+
+// NewCNIProvider returns the NetworkProvider for worker.NetModeDefault. Pass
+// "" for either argument to use the default paths (/etc/buildkit/cni and
+// /opt/cni/bin respectively).
+func NewCNIProvider(configDir, binDir string) worker.NetworkProvider {
+	if configDir == "" {
+		configDir = defaultConfigDir
+	}
+	if binDir == "" {
+		binDir = defaultBinDir
+	}
+	return &CNIProvider{ConfigDir: configDir, BinDir: binDir}
+}
+
+func (p *CNIProvider) init() error {
+	p.initOnce.Do(func() {
+		files, err := libcni.ConfFiles(p.ConfigDir, []string{".conf", ".conflist"})
+		if err != nil {
+			p.initErr = errors.Wrapf(err, "failed to read CNI config dir %s", p.ConfigDir)
+			return
+		}
+		for _, f := range files {
+			var list *libcni.NetworkConfigList
+			if filepath.Ext(f) == ".conflist" {
+				list, err = libcni.ConfListFromFile(f)
+			} else {
+				var conf *libcni.NetworkConfig
+				if conf, err = libcni.ConfFromFile(f); err == nil {
+					list, err = libcni.ConfListFromConf(conf)
+				}
+			}
+			if err != nil {
+				p.initErr = errors.Wrapf(err, "failed to parse CNI config %s", f)
+				return
+			}
+			p.networks = append(p.networks, list)
+		}
+		if len(p.networks) == 0 {
+			p.initErr = errors.Errorf("no CNI network configs found in %s", p.ConfigDir)
+			return
+		}
+		p.cni = libcni.NewCNIConfig([]string{p.BinDir}, nil)
+	})
+	return p.initErr
+}
+
+func (p *CNIProvider) New(ctx context.Context, id string) (worker.NetworkNamespace, error) {
+	if err := p.init(); err != nil {
+		return nil, err
+	}
+
+	path, cleanupNS, err := newNetNS(id)
+	if err != nil {
+		return nil, err
+	}
+
+	ns := &cniNamespace{provider: p, id: id, path: path, cleanupNS: cleanupNS}
+
+	rt := &libcni.RuntimeConf{ContainerID: id, NetNS: path, IfName: cniIfName}
+
+	var last cnitypes.Result
+	for i, netconf := range p.networks {
+		res, err := p.cni.AddNetworkList(ctx, netconf, rt)
+		if err != nil {
+			// Best-effort teardown of whatever already succeeded (i configs'
+			// worth of ADD), then the namespace itself, so a partial ADD
+			// never leaks even though this op is failing before it starts.
+			ns.delNetworks(context.Background(), i)
+			if cerr := cleanupNS(); cerr != nil {
+				err = errors.Wrapf(err, "and failed to remove netns: %v", cerr)
+			}
+			return nil, errors.Wrapf(err, "failed to add CNI network %s to %s", netconf.Name, id)
+		}
+		last = res
+	}
+
+	if last != nil {
+		if ip := firstIP(last); ip != "" {
+			ns.ip = ip
+		}
+	}
+
+	return ns, nil
+}
+
+func firstIP(res cnitypes.Result) string {
+	r, err := current.NewResultFromResult(res)
+	if err != nil || len(r.IPs) == 0 {
+		return ""
+	}
+	return r.IPs[0].Address.IP.String()
+}
+
+type cniNamespace struct {
+	provider  *CNIProvider
+	id        string
+	path      string
+	ip        string
+	cleanupNS func() error
+}
+
+func (n *cniNamespace) Path() string { return n.path }
+func (n *cniNamespace) IP() string   { return n.ip }
+
+// delNetworks runs DEL for the first count configured networks, in reverse
+// order, matching the order they were ADDed so teardown always undoes the
+// plugin chain symmetrically.
+func (n *cniNamespace) delNetworks(ctx context.Context, count int) error {
+	rt := &libcni.RuntimeConf{ContainerID: n.id, NetNS: n.path, IfName: cniIfName}
+	var retErr error
+	for i := count - 1; i >= 0; i-- {
+		if err := n.provider.cni.DelNetworkList(ctx, n.provider.networks[i], rt); err != nil {
+			retErr = err // keep going so every plugin still gets its DEL
+		}
+	}
+	return retErr
+}
+
+func (n *cniNamespace) Close(ctx context.Context) error {
+	retErr := n.delNetworks(ctx, len(n.provider.networks))
+	if n.cleanupNS != nil {
+		if err := n.cleanupNS(); err != nil && retErr == nil {
+			retErr = err
+		}
+	}
+	return retErr
+}
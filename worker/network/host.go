@@ -0,0 +1,26 @@
+package network
+
+import (
+	"context"
+
+	"github.com/moby/buildkit/worker"
+)
+
+// hostProvider hands back the worker process's own network: Path is empty,
+// which tells Worker.Exec not to switch namespaces at all.
+type hostProvider struct{}
+
+// NewHostProvider returns the NetworkProvider for worker.NetModeHost.
+func NewHostProvider() worker.NetworkProvider {
+	return &hostProvider{}
+}
+
+func (p *hostProvider) New(ctx context.Context, id string) (worker.NetworkNamespace, error) {
+	return hostNamespace{}, nil
+}
+
+type hostNamespace struct{}
+
+func (hostNamespace) Path() string                    { return "" }
+func (hostNamespace) IP() string                      { return "" }
+func (hostNamespace) Close(ctx context.Context) error { return nil }
@@ -0,0 +1,48 @@
+package network
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/pkg/errors"
+	"github.com/vishvananda/netns"
+)
+
+// netNSRunDir is the directory `ip netns` uses, where netns.NewNamed
+// bind-mounts every namespace this package creates, so it can be entered by
+// path later, from whichever goroutine ends up execing into it rather than
+// the one that provisioned it.
+const netNSRunDir = "/run/netns"
+
+// newNetNS creates a new, empty network namespace (loopback only) named id
+// and returns its path plus a cleanup func that removes it again.
+func newNetNS(id string) (path string, cleanup func() error, retErr error) {
+	if err := os.MkdirAll(netNSRunDir, 0700); err != nil {
+		return "", nil, errors.Wrapf(err, "failed to create %s", netNSRunDir)
+	}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	cur, err := netns.Get()
+	if err != nil {
+		return "", nil, errors.Wrap(err, "failed to get current netns")
+	}
+	defer cur.Close()
+
+	newNS, err := netns.NewNamed(id)
+	if err != nil {
+		return "", nil, errors.Wrapf(err, "failed to create netns %s", id)
+	}
+	defer newNS.Close()
+
+	if err := netns.Set(cur); err != nil {
+		return "", nil, errors.Wrap(err, "failed to restore calling goroutine's netns")
+	}
+
+	cleanup = func() error {
+		return netns.DeleteNamed(id)
+	}
+	return filepath.Join(netNSRunDir, id), cleanup, nil
+}
@@ -0,0 +1,39 @@
+package network
+
+import (
+	"context"
+
+	"github.com/moby/buildkit/worker"
+)
+
+// noneProvider gives each op its own empty network namespace: no interface
+// other than loopback, so nothing it runs can reach any network at all.
+type noneProvider struct{}
+
+// NewNoneProvider returns the NetworkProvider for worker.NetModeNone.
+func NewNoneProvider() worker.NetworkProvider {
+	return &noneProvider{}
+}
+
+func (p *noneProvider) New(ctx context.Context, id string) (worker.NetworkNamespace, error) {
+	path, cleanup, err := newNetNS(id)
+	if err != nil {
+		return nil, err
+	}
+	return &noneNamespace{path: path, cleanup: cleanup}, nil
+}
+
+type noneNamespace struct {
+	path    string
+	cleanup func() error
+}
+
+func (n *noneNamespace) Path() string { return n.path }
+func (n *noneNamespace) IP() string   { return "" }
+
+func (n *noneNamespace) Close(ctx context.Context) error {
+	if n.cleanup == nil {
+		return nil
+	}
+	return n.cleanup()
+}
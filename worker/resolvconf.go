@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// resolvConfDir is where generated resolv.conf files are written before
+// being bind-mounted into an op's rootfs at /etc/resolv.conf.
+const resolvConfDir = "/run/buildkit/resolvconf"
+
+// WriteResolvConf renders dns as a resolv.conf file and returns its path,
+// for Exec to bind-mount read-only at /etc/resolv.conf. Each call gets its
+// own file, named id, so concurrent execs never race on the same path. A
+// nil dns is a no-op: it returns an empty path and no error.
+func WriteResolvConf(id string, dns *DNSConfig) (string, error) {
+	if dns == nil {
+		return "", nil
+	}
+	if err := os.MkdirAll(resolvConfDir, 0755); err != nil {
+		return "", errors.Wrapf(err, "failed to create %s", resolvConfDir)
+	}
+
+	var b strings.Builder
+	for _, ns := range dns.Nameservers {
+		b.WriteString("nameserver " + ns + "\n")
+	}
+	if len(dns.SearchDomains) > 0 {
+		b.WriteString("search " + strings.Join(dns.SearchDomains, " ") + "\n")
+	}
+	if len(dns.Options) > 0 {
+		b.WriteString("options " + strings.Join(dns.Options, " ") + "\n")
+	}
+
+	path := filepath.Join(resolvConfDir, id)
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", errors.Wrapf(err, "failed to write %s", path)
+	}
+	return path, nil
+}
@@ -0,0 +1,94 @@
+package worker
+
+import (
+	"context"
+	"io"
+	"net"
+
+	"github.com/moby/buildkit/cache"
+)
+
+// Worker executes a single Op_Exec against a set of mounts. Implementations
+// are expected to enter whatever namespaces the op requires (mount, and
+// NetNS if set) before running Args.
+type Worker interface {
+	Exec(ctx context.Context, meta Meta, mounts map[string]cache.Mountable, stdout, stderr io.WriteCloser) error
+}
+
+// Meta describes a single process invocation: what to run, against which
+// mounts, plus whatever the session and network subsystems resolved ahead
+// of time so Exec never has to reach back out to the client or a CNI plugin
+// itself.
+type Meta struct {
+	Args    []string
+	Env     []string
+	Cwd     string
+	Secrets []SecretMount
+	SSH     []SSHMount
+	// NetNS is the namespace the solver provisioned for this op via a
+	// NetworkProvider; nil means the worker's own (host) network.
+	NetNS NetworkNamespace
+	// ResolvConf, if set, is a host path Exec bind-mounts read-only at
+	// /etc/resolv.conf, generated from DNSConfig for a NetNS that doesn't
+	// already come with its own resolver config. Empty means don't mount
+	// one at all.
+	ResolvConf string
+}
+
+// DNSConfig is the daemon-level resolver configuration to apply to an exec
+// op's network namespace when that namespace doesn't already come with its
+// own /etc/resolv.conf. A nil DNSConfig leaves /etc/resolv.conf out of the
+// op's mount set entirely; a non-nil one with every field empty still gets
+// an empty resolv.conf mounted.
+type DNSConfig struct {
+	Nameservers   []string
+	SearchDomains []string
+	Options       []string
+}
+
+// SecretMount is a secret mount already resolved to its content by the
+// session subsystem; Exec is responsible for making Data available at Dest
+// for the life of the process and nowhere else, so it never lands in a
+// committed snapshot layer.
+type SecretMount struct {
+	Dest string
+	Data []byte
+}
+
+// SSHMount is an ssh-agent forward already resolved to a live connection by
+// the session subsystem; Exec proxies Conn at Dest (typically a unix socket
+// matching SSH_AUTH_SOCK) for the life of the process.
+type SSHMount struct {
+	Dest string
+	Conn net.Conn
+}
+
+// NetworkMode selects which NetworkProvider the solver asks to provision a
+// namespace. It mirrors pb.Op_Exec.Meta's network field, but its values are
+// not the same numbers: the solver's networkMode maps one to the other.
+type NetworkMode int
+
+const (
+	NetModeDefault NetworkMode = iota // worker's configured default, typically host
+	NetModeHost
+	NetModeNone
+)
+
+// NetworkProvider sets up the network namespace an exec op should run in
+// and guarantees it is torn down again once the op is done, whether it
+// succeeded or not.
+type NetworkProvider interface {
+	New(ctx context.Context, id string) (NetworkNamespace, error)
+}
+
+// NetworkNamespace is a namespace provisioned for a single exec op.
+type NetworkNamespace interface {
+	// Path is the filesystem path to the namespace (e.g.
+	// /var/run/buildkit/netns/<id>) for the worker to enter before
+	// running Args; empty means "don't switch namespaces" (host mode).
+	Path() string
+	// IP is the address IPAM assigned, once a CNI plugin has run; empty
+	// otherwise.
+	IP() string
+	Close(ctx context.Context) error
+}